@@ -136,7 +136,8 @@ func writeTo(entry *pb.KV, w io.Writer) error {
 // made by calling DB.Backup().
 //
 // DB.Load() should be called on a database that is not running any other
-// concurrent transactions while it is running.
+// concurrent transactions while it is running. To restore a large backup into
+// an empty database faster, write the sorted KVs with a StreamWriter instead.
 func (db *DB) Load(r io.Reader) error {
 	br := bufio.NewReaderSize(r, 16<<10)
 	unmarshalBuf := make([]byte, 1<<10)
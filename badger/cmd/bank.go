@@ -63,7 +63,7 @@ failure of the total invariant.
 var numGoroutines, numAccounts, numPrevious int
 var duration string
 var stopAll int32
-var mmap bool
+var mmap, ssi, jointAccounts, skew bool
 
 const keyPrefix = "account:"
 
@@ -80,6 +80,16 @@ func init() {
 		&numGoroutines, "conc", "c", 16, "Number of concurrent transactions to run.")
 	bankTest.Flags().StringVarP(&duration, "duration", "d", "3m", "How long to run the test.")
 	bankTest.Flags().BoolVarP(&mmap, "mmap", "m", false, "If true, mmap LSM tree. Default is RAM.")
+	bankTest.Flags().BoolVar(&ssi, "ssi", false,
+		"If true, run with serializable snapshot isolation.")
+	testCmd.PersistentFlags().BoolVar(&jointAccounts, "joint", false,
+		"If true, pair up accounts. Money can only be moved out of an account if the pair keeps "+
+			"at least the initial balance of one account. As only one account of the pair gets "+
+			"written to, this catches write skew.")
+	bankTest.Flags().BoolVar(&skew, "skew", false,
+		"If true, move money in and out of the first pair of joint accounts only, so that "+
+			"withdrawals from both accounts of the pair keep running concurrently. Implies --joint. "+
+			"Run with --ssi to check that serializable snapshot isolation prevents the write skew.")
 	bankDisect.Flags().IntVarP(&numPrevious, "previous", "p", 12,
 		"Starting from the violation txn, how many previous versions to retrieve.")
 }
@@ -118,6 +128,23 @@ func getBalance(txn *badger.Txn, account int) (uint64, error) {
 	return bal, err
 }
 
+// scanBalance looks up the balance of the account with an iterator, so that the read is tracked
+// as a range read, rather than a key lookup.
+func scanBalance(txn *badger.Txn, account int) (uint64, error) {
+	itr := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer itr.Close()
+	itr.Seek(key(account))
+	if !itr.Valid() || !bytes.Equal(itr.Item().Key(), key(account)) {
+		return 0, badger.ErrKeyNotFound
+	}
+	var bal uint64
+	err := itr.Item().Value(func(v []byte) error {
+		bal = toUint64(v)
+		return nil
+	})
+	return bal, err
+}
+
 func putBalance(txn *badger.Txn, account int, bal uint64) error {
 	return txn.Set(key(account), toSlice(bal))
 }
@@ -146,6 +173,15 @@ func moveMoney(db *badger.DB, from, to int) error {
 		if floor < 5 {
 			return errAbandoned
 		}
+		if partner := from ^ 1; jointAccounts && partner < numAccounts && partner != to {
+			balp, err := scanBalance(txn, partner)
+			if err != nil {
+				return err
+			}
+			if balf+balp-5 < initialBal {
+				return errAbandoned
+			}
+		}
 		// Move the money.
 		balf -= 5
 		balt += 5
@@ -244,6 +280,16 @@ func seekTotal(txn *badger.Txn) ([]account, error) {
 		atomic.AddInt32(&stopAll, 1)
 		return accounts, errFailure
 	}
+	if jointAccounts {
+		for i := 0; i+1 < numAccounts; i += 2 {
+			if joint := accounts[i].Bal + accounts[i+1].Bal; joint < initialBal {
+				log.Printf("Joint balance of accounts %d and %d is below %d: %d",
+					i, i+1, initialBal, joint)
+				atomic.AddInt32(&stopAll, 1)
+				return accounts, errFailure
+			}
+		}
+	}
 	return accounts, nil
 }
 
@@ -369,6 +415,10 @@ func runTest(cmd *cobra.Command, args []string) error {
 	if mmap {
 		opts.TableLoadingMode = options.MemoryMap
 	}
+	opts.SerializableSnapshotIsolation = ssi
+	if skew {
+		jointAccounts = true
+	}
 	log.Printf("Opening DB with options: %+v\n", opts)
 
 	db, err := badger.Open(opts)
@@ -393,7 +443,7 @@ func runTest(cmd *cobra.Command, args []string) error {
 
 	// startTs := time.Now()
 	endTs := time.Now().Add(dur)
-	var total, errors, reads uint64
+	var total, errors, conflicts, reads uint64
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -437,6 +487,14 @@ func runTest(cmd *cobra.Command, args []string) error {
 				}
 				from := rand.Intn(numAccounts)
 				to := rand.Intn(numAccounts)
+				if skew {
+					// Either withdraw from or deposit to the first pair of joint accounts.
+					if rand.Intn(2) == 0 {
+						from = rand.Intn(2)
+					} else {
+						to = rand.Intn(2)
+					}
+				}
 				if from == to {
 					continue
 				}
@@ -446,6 +504,9 @@ func runTest(cmd *cobra.Command, args []string) error {
 					log.Printf("Moved $5. %d -> %d\n", from, to)
 				} else {
 					atomic.AddUint64(&errors, 1)
+					if err == badger.ErrConflict {
+						atomic.AddUint64(&conflicts, 1)
+					}
 				}
 			}
 		}()
@@ -480,6 +541,9 @@ func runTest(cmd *cobra.Command, args []string) error {
 		}
 	}()
 	wg.Wait()
+	log.Printf("Txns: %d. Errors: %d. Conflicts: %d. Reads: %d.\n",
+		atomic.LoadUint64(&total), atomic.LoadUint64(&errors), atomic.LoadUint64(&conflicts),
+		atomic.LoadUint64(&reads))
 
 	if atomic.LoadInt32(&stopAll) == 0 {
 		log.Println("Test OK")
@@ -18,6 +18,7 @@ package badger
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"expvar"
 	"math"
@@ -74,9 +75,31 @@ type DB struct {
 	writeCh   chan *request
 	flushChan chan flushTask // For flushing memtables.
 
+	// Entries of a txn split across write requests, held back from the memtable until the request
+	// closing the txn is written. Only used by writeRequests, which runs serially.
+	txnParts   []txnPartEntry
+	txnPartsTs uint64
+
+	lastSpillID uint64 // Id of the last spill file created by a txn. Accessed atomically.
+
 	blockWrites int32
 
 	orc *oracle
+
+	// Key locks taken by transactions via Txn.Lock.
+	locks *lockManager
+
+	// rateLimiter limits the rate of disk writes done by compactions, flushes and value log GC, to
+	// compactionRateLimit, which is accessed atomically. With Options.AutoTuneCompactionRate,
+	// compactions and value log GC are further limited by compactionRateLimiter, leaving the rest
+	// of the rate to flushes.
+	compactionRateLimit   int64
+	rateLimiter           *y.RateLimiter
+	compactionRateLimiter *y.RateLimiter
+
+	compactionLock     sync.Mutex // Guards the following, and (re)starting the compactors.
+	compactionsPaused  bool       // Set via PauseCompactions.
+	compactionsStopped bool       // Set via stopCompactions.
 }
 
 const (
@@ -130,7 +153,12 @@ func (db *DB) replayFunction() func(Entry, valuePointer) error {
 			UserMeta: e.UserMeta,
 		}
 
-		if e.meta&bitFinTxn > 0 {
+		if e.meta&bitAbortTxn > 0 {
+			// The txn was abandoned after some of its requests were written. See Txn.sendSpilled.
+			txn = txn[:0]
+			lastCommit = 0
+
+		} else if e.meta&bitFinTxn > 0 {
 			txnTs, err := strconv.ParseUint(string(e.Value), 10, 64)
 			if err != nil {
 				return errors.Wrapf(err, "Unable to parse txn fin: %q", e.Value)
@@ -182,6 +210,9 @@ func Open(opt Options) (db *DB, err error) {
 		// Can't truncate if the DB is read only.
 		opt.Truncate = false
 	}
+	if opt.EventListener == nil {
+		opt.EventListener = NoopEventListener{}
+	}
 
 	for _, path := range []string{opt.Dir, opt.ValueDir} {
 		dirExists, err := exists(path)
@@ -245,6 +276,13 @@ func Open(opt Options) (db *DB, err error) {
 		}
 	}()
 
+	if !opt.ReadOnly {
+		// Txns which didn't commit before the last run ended don't need their spill files.
+		if err := removeSpillFiles(opt.ValueDir); err != nil {
+			return nil, err
+		}
+	}
+
 	db = &DB{
 		imm:           make([]*skl.Skiplist, 0, opt.NumMemtables),
 		flushChan:     make(chan flushTask, opt.NumMemtables),
@@ -255,6 +293,11 @@ func Open(opt Options) (db *DB, err error) {
 		dirLockGuard:  dirLockGuard,
 		valueDirGuard: valueDirLockGuard,
 		orc:           newOracle(opt),
+		locks:         newLockManager(),
+		rateLimiter:   y.NewRateLimiter(opt.CompactionRateLimit),
+
+		compactionRateLimit:   opt.CompactionRateLimit,
+		compactionRateLimiter: y.NewRateLimiter(0),
 	}
 
 	// Calculate initial size.
@@ -267,6 +310,7 @@ func Open(opt Options) (db *DB, err error) {
 	if db.lc, err = newLevelsController(db, &manifest); err != nil {
 		return nil, err
 	}
+	db.tuneCompactionRate()
 
 	if !opt.ReadOnly {
 		db.closers.compactors = y.NewCloser(1)
@@ -299,6 +343,11 @@ func Open(opt Options) (db *DB, err error) {
 	// Let's advance nextTxnTs to one more than whatever we observed via
 	// replaying the logs.
 	db.orc.txnMark.Done(db.orc.nextTxnTs)
+	// Before the DB was closed, compactions might have discarded the versions shadowed by the ones
+	// flushed to tables, which were all committed before the timestamp of the head.
+	if db.orc.nextTxnTs > 0 {
+		db.orc.discardedTs = db.orc.nextTxnTs - 1
+	}
 	db.orc.nextTxnTs++
 
 	db.writeCh = make(chan *request, kvWriteChCapacity)
@@ -503,6 +552,34 @@ func (db *DB) get(key []byte) (y.ValueStruct, error) {
 	return db.lc.get(key, maxVs)
 }
 
+// getMany is like get, for a batch of user keys sorted in ascending order. The values are returned
+// in the same order, with empty ones for the keys which aren't found.
+func (db *DB) getMany(keys [][]byte) ([]y.ValueStruct, error) {
+	tables, decr := db.getMemTables() // Lock should be released.
+	defer decr()
+
+	vs := make([]y.ValueStruct, len(keys))
+	done := make([]bool, len(keys))
+	y.NumGets.Add(int64(len(keys)))
+	for _, mt := range tables {
+		for i, key := range keys {
+			if done[i] {
+				continue
+			}
+			v := mt.Get(key)
+			y.NumMemtableGets.Add(1)
+			if v.Meta == 0 && v.Value == nil {
+				continue
+			}
+			vs[i], done[i] = v, true
+		}
+	}
+	if err := db.lc.getMany(keys, vs, done); err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
 func (db *DB) updateHead(ptrs []valuePointer) {
 	var ptr valuePointer
 	for i := len(ptrs) - 1; i >= 0; i-- {
@@ -532,15 +609,75 @@ func (db *DB) shouldWriteValueToLSM(e Entry) bool {
 	return len(e.Value) < db.opt.ValueThreshold
 }
 
+// txnPartEntry is an entry of a txn split across write requests, as it goes into the memtable.
+type txnPartEntry struct {
+	key []byte
+	vs  y.ValueStruct
+}
+
+// holdTxnPart holds back the entries of a request which leaves its txn open, until the rest of
+// the txn is written. Otherwise, they could be flushed to an SSTable, and survive a crash which
+// cuts the txn short in the value log. The keys and values are copied, apart from values stored
+// in the value log, so a spilled txn takes up to Options.MaxSpilledTxnSize of memory until then.
+func (db *DB) holdTxnPart(b *request) {
+	ts := y.ParseTs(b.Entries[0].Key)
+	if len(db.txnParts) > 0 && db.txnPartsTs != ts {
+		Warningf("Found an incomplete txn at timestamp %d. Discarding it.\n", db.txnPartsTs)
+		db.txnParts = db.txnParts[:0]
+	}
+	db.txnPartsTs = ts
+	for i, entry := range b.Entries {
+		// Copy what's needed, so that the request with the big values isn't kept around.
+		vs := y.ValueStruct{
+			Meta:      entry.meta,
+			UserMeta:  entry.UserMeta,
+			ExpiresAt: entry.ExpiresAt,
+		}
+		if db.shouldWriteValueToLSM(*entry) {
+			vs.Value = y.SafeCopy(nil, entry.Value)
+		} else {
+			vs.Value = b.Ptrs[i].Encode(make([]byte, vptrSize))
+			vs.Meta |= bitValuePointer
+		}
+		db.txnParts = append(db.txnParts, txnPartEntry{key: y.SafeCopy(nil, entry.Key), vs: vs})
+	}
+}
+
 func (db *DB) writeToLSM(b *request) error {
 	if len(b.Ptrs) != len(b.Entries) {
 		return errors.Errorf("Ptrs and Entries don't match: %+v", b)
 	}
 
+	if len(db.txnParts) > 0 {
+		parts := db.txnParts
+		db.txnParts = nil
+		first := b.Entries[0]
+		if first.meta&(bitTxn|bitFinTxn) == 0 || y.ParseTs(first.Key) != db.txnPartsTs {
+			Warningf("Found an incomplete txn at timestamp %d. Discarding it.\n", db.txnPartsTs)
+			parts = nil
+		}
+		for _, p := range parts {
+			if db.mt.MemSize() >= db.opt.MaxTableSize {
+				if err := db.makeRoomForWrite(); err != nil {
+					return err
+				}
+			}
+			db.mt.Put(p.key, p.vs)
+		}
+	}
+
 	for i, entry := range b.Entries {
 		if entry.meta&bitFinTxn != 0 {
 			continue
 		}
+		if db.mt.MemSize() >= db.opt.MaxTableSize {
+			// Only txns split across requests don't fit into the memtable in one go. Their entries
+			// are in the value log already, so they can be replayed if the memtable gets flushed
+			// midway.
+			if err := db.makeRoomForWrite(); err != nil {
+				return err
+			}
+		}
 		if db.shouldWriteValueToLSM(*entry) { // Will include deletion / tombstone case.
 			db.mt.Put(entry.Key,
 				y.ValueStruct{
@@ -574,6 +711,10 @@ func (db *DB) writeRequests(reqs []*request) error {
 			r.Err = err
 			r.Wg.Done()
 		}
+		if err != nil {
+			// The txn held back can't be completed.
+			db.txnParts = nil
+		}
 	}
 	db.elog.Printf("writeRequests called. Writing to value log")
 
@@ -590,18 +731,15 @@ func (db *DB) writeRequests(reqs []*request) error {
 			continue
 		}
 		count += len(b.Entries)
-		var i uint64
-		for err := db.ensureRoomForWrite(); err == errNoRoom; err = db.ensureRoomForWrite() {
-			i++
-			if i%100 == 0 {
-				db.elog.Printf("Making room for writes")
-			}
-			// We need to poll a bit because both hasRoomForWrite and the flusher need access to s.imm.
-			// When flushChan is full and you are blocked there, and the flusher is trying to update s.imm,
-			// you will get a deadlock.
-			time.Sleep(10 * time.Millisecond)
+		if b.leavesTxnOpen() {
+			db.holdTxnPart(b)
+			continue
 		}
-		if err != nil {
+		if b.abortsTxn() {
+			db.txnParts = nil
+			continue
+		}
+		if err := db.makeRoomForWrite(); err != nil {
 			done(err)
 			return errors.Wrap(err, "writeRequests")
 		}
@@ -616,7 +754,33 @@ func (db *DB) writeRequests(reqs []*request) error {
 	return nil
 }
 
-func (db *DB) sendToWriteCh(entries []*Entry) (*request, error) {
+// makeRoomForWrite blocks until there's room in the memtable for a write request.
+func (db *DB) makeRoomForWrite() error {
+	var i uint64
+	err := db.ensureRoomForWrite()
+	for ; err == errNoRoom; err = db.ensureRoomForWrite() {
+		i++
+		if i%100 == 0 {
+			db.elog.Printf("Making room for writes")
+		}
+		// We need to poll a bit because both hasRoomForWrite and the flusher need access to s.imm.
+		// When flushChan is full and you are blocked there, and the flusher is trying to update s.imm,
+		// you will get a deadlock.
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+// sendToWriteCh hands the entries off to be written. It gives up waiting for room in the write
+// channel once ctx is done.
+func (db *DB) sendToWriteCh(ctx context.Context, entries []*Entry) (*request, error) {
+	return db.sendTxnPartToWriteCh(ctx, entries, 0)
+}
+
+// sendTxnPartToWriteCh acts like sendToWriteCh, for the requests of a txn split across requests.
+// The first one passes the size the whole txn takes in the value log as txnSize, and the rest 0.
+func (db *DB) sendTxnPartToWriteCh(ctx context.Context, entries []*Entry,
+	txnSize int64) (*request, error) {
 	if atomic.LoadInt32(&db.blockWrites) == 1 {
 		return nil, ErrBlockedWrites
 	}
@@ -633,9 +797,15 @@ func (db *DB) sendToWriteCh(entries []*Entry) (*request, error) {
 	// Txns should not interleave among other txns or rewrites.
 	req := requestPool.Get().(*request)
 	req.Entries = entries
+	req.txnSize = txnSize
 	req.Wg = sync.WaitGroup{}
 	req.Wg.Add(1)
-	db.writeCh <- req // Handled in doWrites.
+	select {
+	case db.writeCh <- req: // Handled in doWrites.
+	case <-ctx.Done():
+		requestPool.Put(req)
+		return nil, ctx.Err()
+	}
 	y.NumPuts.Add(int64(len(entries)))
 
 	return req, nil
@@ -705,7 +875,10 @@ func (db *DB) doWrites(lc *y.Closer) {
 // will be returned.
 //   Check(kv.BatchSet(entries))
 func (db *DB) batchSet(entries []*Entry) error {
-	req, err := db.sendToWriteCh(entries)
+	// Like txns, don't let the entries come between the requests of a txn split across several.
+	db.orc.writeChLock.Lock()
+	req, err := db.sendToWriteCh(context.Background(), entries)
+	db.orc.writeChLock.Unlock()
 	if err != nil {
 		return err
 	}
@@ -720,7 +893,9 @@ func (db *DB) batchSet(entries []*Entry) error {
 //      Check(err)
 //   }
 func (db *DB) batchSetAsync(entries []*Entry, f func(error)) error {
-	req, err := db.sendToWriteCh(entries)
+	db.orc.writeChLock.Lock()
+	req, err := db.sendToWriteCh(context.Background(), entries)
+	db.orc.writeChLock.Unlock()
 	if err != nil {
 		return err
 	}
@@ -771,18 +946,18 @@ func arenaSize(opt Options) int64 {
 }
 
 // WriteLevel0Table flushes memtable.
-func writeLevel0Table(s *skl.Skiplist, f *os.File) error {
+func writeLevel0Table(s *skl.Skiplist, f *os.File, rl *y.RateLimiter) error {
 	iter := s.NewIterator()
 	defer iter.Close()
 	b := table.NewTableBuilder()
 	defer b.Close()
 	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
-		if err := b.Add(iter.Key(), iter.Value()); err != nil {
+		vs := iter.Value()
+		if err := b.Add(iter.Key(), vs, vs.Meta&bitDelete > 0); err != nil {
 			return err
 		}
 	}
-	_, err := f.Write(b.Finish())
-	return err
+	return writeRateLimited(f, b.Finish(), rl)
 }
 
 type flushTask struct {
@@ -791,7 +966,7 @@ type flushTask struct {
 }
 
 // handleFlushTask must be run serially.
-func (db *DB) handleFlushTask(ft flushTask) error {
+func (db *DB) handleFlushTask(ft flushTask) (err error) {
 	if !ft.mt.Empty() {
 		// Store badger head even if vptr is zero, need it for readTs
 		Infof("Storing value log head: %+v\n", ft.vptr)
@@ -806,6 +981,14 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 	}
 
 	fileID := db.lc.reserveFileID()
+	info := FlushInfo{MemtableSize: ft.mt.MemSize(), Table: TableInfo{ID: fileID}}
+	db.opt.EventListener.OnFlushBegin(info)
+	timeStart := time.Now()
+	defer func() {
+		info.Duration = time.Since(timeStart)
+		db.opt.EventListener.OnFlushEnd(info, err)
+	}()
+
 	fd, err := y.CreateSyncedFile(table.NewFilename(fileID, db.opt.Dir), true)
 	if err != nil {
 		return y.Wrap(err)
@@ -815,7 +998,7 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 	dirSyncCh := make(chan error)
 	go func() { dirSyncCh <- syncDir(db.opt.Dir) }()
 
-	err = writeLevel0Table(ft.mt, fd)
+	err = writeLevel0Table(ft.mt, fd, db.rateLimiter)
 	dirSyncErr := <-dirSyncCh
 
 	if err != nil {
@@ -832,6 +1015,7 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 		db.elog.Printf("ERROR while opening table: %v", err)
 		return err
 	}
+	info.Table = newTableInfo(tbl, 0)
 	// We own a ref on tbl.
 	err = db.lc.addLevel0Table(tbl) // This will incrRef (if we don't error, sure)
 	tbl.DecrRef()                   // Releases our ref.
@@ -1121,6 +1305,82 @@ func (db *DB) KeySplits(prefix []byte) []string {
 	return splits
 }
 
+// EstimateSize returns the approximate size in bytes of the keys in [start, end), without scanning
+// them. An empty end is unbounded. It adds up the matching entries in the memtables, and the table
+// blocks holding the range as per their block indexes, so it can be off by a block per table at
+// each end of the range. Values stored in the value log only count with the size of their
+// pointers.
+func (db *DB) EstimateSize(start, end []byte) int64 {
+	size, _ := db.estimateRange(start, end)
+	return size
+}
+
+// EstimateKeyCount returns the approximate number of keys in [start, end), without scanning them.
+// An empty end is unbounded. Like the table Stats, it counts every version of a key, and delete
+// markers.
+func (db *DB) EstimateKeyCount(start, end []byte) uint64 {
+	_, count := db.estimateRange(start, end)
+	return count
+}
+
+func (db *DB) estimateRange(start, end []byte) (size int64, count uint64) {
+	tables, decr := db.getMemTables()
+	defer decr()
+	for _, mt := range tables {
+		// Memtables are small enough to go through.
+		it := mt.NewIterator()
+		if len(start) > 0 {
+			it.Seek(y.KeyWithTs(start, math.MaxUint64))
+		} else {
+			it.SeekToFirst()
+		}
+		for ; it.Valid(); it.Next() {
+			if len(end) > 0 && bytes.Compare(y.ParseKey(it.Key()), end) >= 0 {
+				break
+			}
+			vs := it.Value()
+			size += int64(len(it.Key())) + int64(vs.EncodedSize())
+			count++
+		}
+		it.Close()
+	}
+
+	sz, n := db.lc.estimateRange(start, end)
+	return size + sz, count + n
+}
+
+// SetCompactionRateLimit changes the maximum number of bytes per second written to disk by
+// compactions, memtable flushes and value log GC rewrites. Zero removes the limit. If
+// AutoTuneCompactionRate is set, this is the upper bound of the tuned rate.
+func (db *DB) SetCompactionRateLimit(bytesPerSec int64) {
+	atomic.StoreInt64(&db.compactionRateLimit, bytesPerSec)
+	db.tuneCompactionRate()
+}
+
+// tuneCompactionRate sets the rate limiters based on the compaction rate limit. With auto tuning,
+// compactions only get a quarter of the limit while level 0 is healthy, scaling up to the full
+// limit as the number of level 0 tables approaches NumLevelZeroTablesStall, at which point writes
+// would stall. Memtable flushes can always use the full limit, so they don't push level 0 towards
+// stalling.
+func (db *DB) tuneCompactionRate() {
+	limit := atomic.LoadInt64(&db.compactionRateLimit)
+	db.rateLimiter.SetRate(limit)
+	if !db.opt.AutoTuneCompactionRate || limit <= 0 {
+		db.compactionRateLimiter.SetRate(0)
+		return
+	}
+
+	lo, hi := db.opt.NumLevelZeroTables, db.opt.NumLevelZeroTablesStall
+	min := limit / 4
+	rate := min
+	if n := db.lc.levels[0].numTables(); n >= hi {
+		rate = limit
+	} else if n > lo {
+		rate = min + (limit-min)*int64(n-lo)/int64(hi-lo)
+	}
+	db.compactionRateLimiter.SetRate(rate)
+}
+
 // MaxBatchCount returns max possible entries in batch
 func (db *DB) MaxBatchCount() int64 {
 	return db.opt.maxBatchCount
@@ -1138,17 +1398,27 @@ func (db *DB) stopCompactions() {
 		db.closers.memtable.SignalAndWait()
 	}
 	// Stop compactions.
+	db.compactionLock.Lock()
+	defer db.compactionLock.Unlock()
 	if db.closers.compactors != nil {
-		db.closers.compactors.SignalAndWait()
+		if !db.compactionsPaused {
+			db.closers.compactors.Signal()
+		}
+		db.closers.compactors.Wait()
 	}
+	db.compactionsStopped = true
 }
 
 func (db *DB) startCompactions() {
-	// Resume compactions.
-	if db.closers.compactors != nil {
+	// Resume compactions, unless the user has paused them.
+	db.compactionLock.Lock()
+	db.compactionsStopped = false
+	if db.closers.compactors != nil && !db.compactionsPaused {
 		db.closers.compactors = y.NewCloser(1)
 		db.lc.startCompact(db.closers.compactors)
 	}
+	db.compactionLock.Unlock()
+
 	if db.closers.memtable != nil {
 		db.flushChan = make(chan flushTask, db.opt.NumMemtables)
 		db.closers.memtable = y.NewCloser(1)
@@ -1156,6 +1426,63 @@ func (db *DB) startCompactions() {
 	}
 }
 
+// PauseCompactions stops the compactors from picking up any new work, and waits for the
+// compactions already in flight to finish. Once it returns nil, no tables are written or deleted
+// by compactions until ResumeCompactions is called. This can be used to take a consistent copy of
+// the files on disk, or to keep background I/O away from latency critical jobs.
+//
+// Memtable flushes are not paused, so that writes don't block. They only add new tables to level
+// zero. Writes will still stall once level zero reaches NumLevelZeroTablesStall tables, so
+// compactions shouldn't be paused for long under heavy writes.
+//
+// If ctx is done before the in-flight compactions finish, ctx.Err() is returned. Compactions are
+// still considered paused in that case, and the in-flight ones finish in the background.
+// ResumeCompactions must be called either way.
+func (db *DB) PauseCompactions(ctx context.Context) error {
+	db.compactionLock.Lock()
+	if db.compactionsPaused || db.closers.compactors == nil {
+		db.compactionsPaused = true
+		db.compactionLock.Unlock()
+		return nil
+	}
+	db.compactionsPaused = true
+	c := db.closers.compactors
+	if !db.compactionsStopped {
+		c.Signal()
+	}
+	db.compactionLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ResumeCompactions restarts the compactors stopped by PauseCompactions. It is a no-op if
+// compactions aren't paused.
+func (db *DB) ResumeCompactions() {
+	db.compactionLock.Lock()
+	defer db.compactionLock.Unlock()
+	if !db.compactionsPaused {
+		return
+	}
+	db.compactionsPaused = false
+	if db.closers.compactors == nil || db.compactionsStopped {
+		return
+	}
+	// PauseCompactions might have given up on waiting for the old compactors.
+	db.closers.compactors.Wait()
+	db.closers.compactors = y.NewCloser(1)
+	db.lc.startCompact(db.closers.compactors)
+}
+
 // Flatten can be used to force compactions on the LSM tree so all the tables fall on the same
 // level. This ensures that all the versions of keys are colocated and not split across multiple
 // levels, which is necessary after a restore from backup. During Flatten, live compactions are
@@ -1240,6 +1567,14 @@ func (db *DB) Flatten(workers int) error {
 // any reads while DropAll is going on, otherwise they may result in panics. Ideally, both reads and
 // writes are paused before running DropAll, and resumed after it is finished.
 func (db *DB) DropAll() error {
+	resume, err := db.dropAll()
+	resume()
+	return err
+}
+
+// dropAll does the work of DropAll, except that writes, memtable flushes and compactions stay
+// paused until the returned resume func is called. It must be called, even if there's an error.
+func (db *DB) dropAll() (func(), error) {
 	if db.opt.ReadOnly {
 		panic("Attempting to drop data in read-only mode.")
 	}
@@ -1253,7 +1588,7 @@ func (db *DB) DropAll() error {
 
 	// Stop all compactions.
 	db.stopCompactions()
-	defer func() {
+	resume := func() {
 		Infof("Resuming writes")
 		db.startCompactions()
 
@@ -1263,7 +1598,7 @@ func (db *DB) DropAll() error {
 
 		// Resume writes.
 		atomic.StoreInt32(&db.blockWrites, 0)
-	}()
+	}
 	Infof("Compactions stopped. Dropping all SSTables...")
 
 	// Remove inmemory tables. Calling DecrRef for safety. Not sure if they're absolutely needed.
@@ -1273,18 +1608,19 @@ func (db *DB) DropAll() error {
 		mt.DecrRef()
 	}
 	db.imm = db.imm[:0]
+	db.txnParts = nil
 
 	num, err := db.lc.deleteLSMTree()
 	if err != nil {
-		return err
+		return resume, err
 	}
 	Infof("Deleted %d SSTables. Now deleting value logs...\n", num)
 
 	num, err = db.vlog.dropAll()
 	if err != nil {
-		return err
+		return resume, err
 	}
 	db.vhead = valuePointer{} // Zero it out.
 	Infof("Deleted %d value log files. DropAll done.\n", num)
-	return nil
+	return resume, nil
 }
@@ -1690,3 +1690,36 @@ func TestMain(m *testing.M) {
 	}()
 	os.Exit(m.Run())
 }
+
+func TestEstimateSize(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := func(prefix string, i int) []byte { return []byte(fmt.Sprintf("%s%05d", prefix, i)) }
+		for _, prefix := range []string{"a", "b", "c"} {
+			batch := db.NewWriteBatch()
+			for i := 0; i < 3000; i++ {
+				require.NoError(t, batch.Set(key(prefix, i), []byte("value"), 0))
+			}
+			require.NoError(t, batch.Flush())
+		}
+		// Some keys are flushed to tables, while the rest are still in the memtable.
+		require.NotEmpty(t, db.Tables())
+
+		// The estimate is off by at most a block of 100 keys per table, at each end of the range.
+		slack := uint64(2 * 100 * len(db.Tables()))
+		check := func(start, end []byte, expected uint64) {
+			count := db.EstimateKeyCount(start, end)
+			require.True(t, count >= expected && count <= expected+slack,
+				"range: [%q, %q), count: %d", start, end, count)
+		}
+		for _, prefix := range []string{"a", "b", "c"} {
+			check([]byte(prefix), []byte(prefix+"\xff"), 3000)
+		}
+		check(nil, nil, 9000)
+		check(key("b", 1000), key("b", 2000), 1000)
+		check([]byte("d"), nil, 0)
+
+		total := db.EstimateSize(nil, nil)
+		b := db.EstimateSize([]byte("b"), []byte("c"))
+		require.InDelta(t, 0.33, float64(b)/float64(total), 0.1)
+	})
+}
@@ -17,6 +17,8 @@
 package badger
 
 import (
+	"fmt"
+
 	"github.com/pkg/errors"
 )
 
@@ -36,9 +38,27 @@ var (
 	ErrTxnTooBig = errors.New("Txn is too big to fit into one request")
 
 	// ErrConflict is returned when a transaction conflicts with another transaction. This can happen if
-	// the read rows had been updated concurrently by another transaction.
+	// the read rows had been updated concurrently by another transaction. With
+	// Options.KeepConflictKeys set, a *ConflictError is returned instead, which matches ErrConflict
+	// under errors.Is.
 	ErrConflict = errors.New("Transaction Conflict. Please retry")
 
+	// ErrDeadlock is returned by Txn.Lock if waiting for the lock would deadlock, because the
+	// holder of the lock is waiting for a lock held by this transaction, directly or indirectly.
+	ErrDeadlock = errors.New("Waiting for the lock would deadlock. Please retry")
+
+	// ErrLockTimeout is returned by Txn.Lock if the lock wasn't acquired within
+	// Options.LockTimeout.
+	ErrLockTimeout = errors.New("Timed out waiting for the lock. Please retry")
+
+	// ErrCommitPending is returned by Txn.CommitCtx if the context is done after the writes of the
+	// transaction have been handed off. They might still be committed, or fail.
+	ErrCommitPending = errors.New("Gave up waiting for the commit. It may or may not go through")
+
+	// ErrInvalidSavepoint is returned by Txn.RollbackTo if the savepoint wasn't taken on the
+	// transaction, or was invalidated by rolling back to an earlier savepoint.
+	ErrInvalidSavepoint = errors.New("Invalid savepoint for this transaction")
+
 	// ErrReadOnlyTxn is returned if an update function is called on a read-only transaction.
 	ErrReadOnlyTxn = errors.New("No sets or deletes are allowed in a read-only transaction")
 
@@ -102,4 +122,33 @@ var (
 	// ErrBlockedWrites is returned if the user called DropAll. During the process of dropping all
 	// data from Badger, we stop accepting new writes, by returning this error.
 	ErrBlockedWrites = errors.New("Writes are blocked, possibly due to DropAll or Close")
+
+	// ErrVersionDiscarded is returned by NewTransactionAtVersion if the versions at the requested
+	// timestamp might have been discarded by compactions already.
+	ErrVersionDiscarded = errors.New("Versions at this timestamp might have been discarded")
+
+	// ErrKeysOnly is returned when reading the value of an item from an iterator with
+	// IteratorOptions.KeysOnly set.
+	ErrKeysOnly = errors.New("Value is not available when iterating over keys only")
 )
+
+// ConflictError is returned on commit instead of ErrConflict if Options.KeepConflictKeys is set. It
+// names the keys read by the transaction, which were written by other transactions since.
+type ConflictError struct {
+	// Keys contains the conflicting keys, either read directly or within the range covered by an
+	// iterator. With Options.SerializableSnapshotIsolation, it also contains the keys written by
+	// the transaction, which were read by concurrent transactions.
+	Keys [][]byte
+	// CommitTs is the latest commit timestamp among the conflicting writes.
+	CommitTs uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s. Conflicting keys: %q, committed at: %d",
+		ErrConflict.Error(), e.Keys, e.CommitTs)
+}
+
+// Is makes a *ConflictError match ErrConflict with errors.Is.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/table"
+)
+
+// EventListener gets notified about the background work done by Badger: memtable flushes,
+// compactions, write stalls and value log GC. The callbacks are called synchronously from the
+// goroutine doing the work, so they should return quickly, and must not call back into the DB.
+//
+// Embed NoopEventListener to only implement the callbacks of interest.
+type EventListener interface {
+	// OnFlushBegin is called before a memtable gets written out to a level 0 table.
+	OnFlushBegin(info FlushInfo)
+	// OnFlushEnd is called once the flush is done, or failed with err.
+	OnFlushEnd(info FlushInfo, err error)
+
+	// OnCompactionBegin is called once the tables to be compacted have been picked.
+	OnCompactionBegin(info CompactionInfo)
+	// OnCompactionEnd is called once the compaction is done, or failed with err.
+	OnCompactionEnd(info CompactionInfo, err error)
+
+	// OnStallBegin is called when writes stall, because level 0 has too many tables.
+	OnStallBegin(info StallInfo)
+	// OnStallEnd is called when writes resume after a stall.
+	OnStallEnd(info StallInfo)
+
+	// OnVlogGC is called after value log GC rewrote a value log file.
+	OnVlogGC(info VlogGCInfo)
+}
+
+// FlushInfo describes a memtable flush.
+type FlushInfo struct {
+	MemtableSize int64     // Size of the memtable in bytes.
+	Table        TableInfo // The level 0 table written. Only the ID is set before the flush ends.
+	Duration     time.Duration
+}
+
+// CompactionInfo describes a compaction.
+type CompactionInfo struct {
+	Level       int         // Level the compaction started from.
+	NextLevel   int         // Level the compaction wrote to.
+	Inputs      []TableInfo // Tables compacted away.
+	Outputs     []TableInfo // Tables written. Only set once the compaction ends.
+	TrivialMove bool        // Inputs were moved to NextLevel as they were, without a rewrite.
+	Duration    time.Duration
+}
+
+// StallInfo describes a write stall.
+type StallInfo struct {
+	NumLevelZeroTables int           // Number of tables in level 0 when the stall began.
+	Duration           time.Duration // Only set once the stall ends.
+}
+
+// VlogGCInfo describes the rewrite of a value log file by value log GC.
+type VlogGCInfo struct {
+	Fid      uint32 // ID of the value log file rewritten.
+	Count    int    // Number of entries read from the file.
+	Moved    int    // Number of entries still valid, and so moved to the head of the log.
+	Duration time.Duration
+}
+
+// NoopEventListener is an EventListener which does nothing.
+type NoopEventListener struct{}
+
+// OnFlushBegin implements EventListener.
+func (NoopEventListener) OnFlushBegin(info FlushInfo) {}
+
+// OnFlushEnd implements EventListener.
+func (NoopEventListener) OnFlushEnd(info FlushInfo, err error) {}
+
+// OnCompactionBegin implements EventListener.
+func (NoopEventListener) OnCompactionBegin(info CompactionInfo) {}
+
+// OnCompactionEnd implements EventListener.
+func (NoopEventListener) OnCompactionEnd(info CompactionInfo, err error) {}
+
+// OnStallBegin implements EventListener.
+func (NoopEventListener) OnStallBegin(info StallInfo) {}
+
+// OnStallEnd implements EventListener.
+func (NoopEventListener) OnStallEnd(info StallInfo) {}
+
+// OnVlogGC implements EventListener.
+func (NoopEventListener) OnVlogGC(info VlogGCInfo) {}
+
+func tableInfos(tables []*table.Table, level int) []TableInfo {
+	infos := make([]TableInfo, 0, len(tables))
+	for _, t := range tables {
+		infos = append(infos, newTableInfo(t, level))
+	}
+	return infos
+}
+
+func newTableInfo(t *table.Table, level int) TableInfo {
+	return TableInfo{
+		ID:    t.ID(),
+		Level: level,
+		Left:  t.Smallest(),
+		Right: t.Biggest(),
+		Size:  t.Size(),
+	}
+}
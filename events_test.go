@@ -0,0 +1,143 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/trace"
+)
+
+type recordingListener struct {
+	NoopEventListener
+	sync.Mutex
+	flushes     []FlushInfo
+	compactions []CompactionInfo
+	numBegins   int
+	gcs         []VlogGCInfo
+}
+
+func (l *recordingListener) OnFlushBegin(info FlushInfo) {
+	l.Lock()
+	defer l.Unlock()
+	l.numBegins++
+}
+
+func (l *recordingListener) OnFlushEnd(info FlushInfo, err error) {
+	l.Lock()
+	defer l.Unlock()
+	if err == nil {
+		l.flushes = append(l.flushes, info)
+	}
+}
+
+func (l *recordingListener) OnCompactionBegin(info CompactionInfo) {
+	l.Lock()
+	defer l.Unlock()
+	l.numBegins++
+}
+
+func (l *recordingListener) OnCompactionEnd(info CompactionInfo, err error) {
+	l.Lock()
+	defer l.Unlock()
+	if err == nil {
+		l.compactions = append(l.compactions, info)
+	}
+}
+
+func (l *recordingListener) OnVlogGC(info VlogGCInfo) {
+	l.Lock()
+	defer l.Unlock()
+	l.gcs = append(l.gcs, info)
+}
+
+func TestEventListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l := &recordingListener{}
+	opt := getTestOptions(dir)
+	opt.EventListener = l
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	for i := 0; i < 2000; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("%08d", i)), []byte(fmt.Sprintf("val%d", i)), 0)
+	}
+	// Closing the DB flushes the memtable, and compacts level 0.
+	require.NoError(t, db.Close())
+
+	l.Lock()
+	defer l.Unlock()
+	require.True(t, len(l.flushes) > 0)
+	require.True(t, len(l.compactions) > 0)
+	require.Equal(t, len(l.flushes)+len(l.compactions), l.numBegins)
+	for _, f := range l.flushes {
+		require.Equal(t, 0, f.Table.Level)
+		require.True(t, f.Table.Size > 0)
+		require.NotNil(t, f.Table.Left)
+	}
+	for _, c := range l.compactions {
+		require.True(t, len(c.Inputs) > 0)
+		require.True(t, len(c.Outputs) > 0)
+		for _, out := range c.Outputs {
+			require.Equal(t, c.NextLevel, out.Level)
+		}
+	}
+}
+
+func TestEventListenerVlogGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l := &recordingListener{}
+	opt := getTestOptions(dir)
+	opt.ValueLogFileSize = 1 << 20
+	opt.EventListener = l
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), make([]byte, 32<<10), 0)
+	}
+	for i := 0; i < 45; i++ {
+		txnDelete(t, db, []byte(fmt.Sprintf("key%d", i)))
+	}
+
+	db.vlog.filesLock.RLock()
+	lf := db.vlog.filesMap[db.vlog.sortedFids()[0]]
+	db.vlog.filesLock.RUnlock()
+
+	tr := trace.New("Test", "Test")
+	defer tr.Finish()
+	require.NoError(t, db.vlog.rewrite(lf, tr))
+
+	l.Lock()
+	defer l.Unlock()
+	require.Equal(t, 1, len(l.gcs))
+	require.Equal(t, lf.fid, l.gcs[0].Fid)
+	require.True(t, l.gcs[0].Moved > 0)
+	require.True(t, l.gcs[0].Moved < l.gcs[0].Count)
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"hash/crc32"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -53,6 +54,8 @@ type Item struct {
 	next      *Item
 	version   uint64
 	txn       *Txn
+	keysOnly  bool           // Set for items from a KeysOnly iterator, whose values can't be read.
+	stats     *IteratorStats // Set for items from an iterator, to count the value log reads.
 }
 
 // String returns a string representation of Item
@@ -97,6 +100,9 @@ func (item *Item) Version() uint64 {
 // instead, or copy it yourself. Value might change once discard or commit is called.
 // Use ValueCopy if you want to do a Set after Get.
 func (item *Item) Value(fn func(val []byte) error) error {
+	if item.keysOnly {
+		return ErrKeysOnly
+	}
 	item.wg.Wait()
 	if item.status == prefetched {
 		if item.err == nil && fn != nil {
@@ -122,6 +128,9 @@ func (item *Item) Value(fn func(val []byte) error) error {
 // This function is useful in long running iterate/update transactions to avoid a write deadlock.
 // See Github issue: https://github.com/dgraph-io/badger/issues/315
 func (item *Item) ValueCopy(dst []byte) ([]byte, error) {
+	if item.keysOnly {
+		return nil, ErrKeysOnly
+	}
 	item.wg.Wait()
 	if item.status == prefetched {
 		return y.SafeCopy(dst, item.val), item.err
@@ -168,6 +177,11 @@ func (item *Item) yieldItemValue() ([]byte, func(), error) {
 		var vp valuePointer
 		vp.Decode(item.vptr)
 		result, cb, err := item.db.vlog.Read(vp, item.slice)
+		if err == nil && item.stats != nil {
+			// Values might be prefetched in parallel.
+			atomic.AddInt64(&item.stats.ValueLogReads, 1)
+			atomic.AddInt64(&item.stats.ValueLogBytesRead, int64(vp.Len))
+		}
 		if err != ErrRetry {
 			return result, cb, err
 		}
@@ -251,7 +265,7 @@ func (item *Item) EstimatedSize() int64 {
 // ValueSize returns the exact size of the value.
 //
 // This can be called to quickly estimate the size of a value without fetching
-// it.
+// it. It's also available with IteratorOptions.KeysOnly.
 func (item *Item) ValueSize() int64 {
 	if !item.hasValue() {
 		return 0
@@ -324,16 +338,35 @@ type IteratorOptions struct {
 	Reverse      bool // Direction of iteration. False is forward, true is backward.
 	AllVersions  bool // Fetch all valid versions of the same key.
 
+	// KeysOnly makes the iterator never touch the value log, which is faster for counting or
+	// listing keys. PrefetchValues is ignored, and Item.Value returns ErrKeysOnly. Item.ValueSize
+	// can still be used.
+	KeysOnly bool
+
 	// The following option is used to narrow down the SSTables that iterator picks up. If
 	// Prefix is specified, only tables which could have this prefix are picked based on their range
 	// of keys.
 	Prefix      []byte // Only iterate over this given prefix.
 	prefixIsKey bool   // If set, use the prefix for bloom filter lookup.
 
+	// LowerBound and UpperBound restrict the iteration to the keys in [LowerBound, UpperBound).
+	// Like Prefix, they are also used to skip the SSTables outside of that range. An empty bound
+	// leaves that side of the range open.
+	LowerBound []byte
+	UpperBound []byte
+
 	internalAccess bool // Used to allow internal access to badger keys.
+	committedOnly  bool // Leave out the pending writes of the txn.
+	untracked      bool // Not counted towards the txn's running iterators.
 }
 
 func (opt *IteratorOptions) PickTable(t table.TableInterface) bool {
+	if len(opt.LowerBound) > 0 && bytes.Compare(y.ParseKey(t.Biggest()), opt.LowerBound) < 0 {
+		return false
+	}
+	if len(opt.UpperBound) > 0 && bytes.Compare(y.ParseKey(t.Smallest()), opt.UpperBound) >= 0 {
+		return false
+	}
 	if len(opt.Prefix) == 0 {
 		return true
 	}
@@ -357,6 +390,23 @@ func (opt *IteratorOptions) PickTable(t table.TableInterface) bool {
 	return true
 }
 
+// inBounds returns true if key lies within the LowerBound and UpperBound.
+func (opt *IteratorOptions) inBounds(key []byte) bool {
+	if len(opt.LowerBound) > 0 && bytes.Compare(key, opt.LowerBound) < 0 {
+		return false
+	}
+	return len(opt.UpperBound) == 0 || bytes.Compare(key, opt.UpperBound) < 0
+}
+
+// pastBound returns true if key lies beyond the bound the iteration is heading towards, so that
+// none of the following keys can be in bounds either.
+func (opt *IteratorOptions) pastBound(key []byte) bool {
+	if opt.Reverse {
+		return len(opt.LowerBound) > 0 && bytes.Compare(key, opt.LowerBound) < 0
+	}
+	return len(opt.UpperBound) > 0 && bytes.Compare(key, opt.UpperBound) >= 0
+}
+
 // DefaultIteratorOptions contains default options when iterating over Badger key-value stores.
 var DefaultIteratorOptions = IteratorOptions{
 	PrefetchValues: true,
@@ -378,9 +428,58 @@ type Iterator struct {
 
 	lastKey []byte // Used to skip over multiple versions of the same key.
 
+	// reverse is the direction of Next, as set by IteratorOptions.Reverse. opt.Reverse holds the
+	// direction the iterator is currently moving in, which Prev and SeekForPrev switch.
+	reverse bool
+
+	// Key passed to the last Seek, from which the iterator has covered a range of keys. For update
+	// txns, the range is registered with the txn to detect conflicts. See trackReadRange.
+	seekKey []byte
+	seeked  bool
+
+	stats         *IteratorStats
+	blockCounters []blockCounter // Table iterators, which count the blocks they load.
+
+	spillItrs []*spillIterator // Iterators over the writes spilled by the txn, if any.
+
 	closed bool
 }
 
+// IteratorStats describes the work an Iterator has done, to help figure out why an iteration is
+// slow. See Iterator.Stats.
+type IteratorStats struct {
+	TablesPicked  int64 // Tables iterated over.
+	TablesSkipped int64 // Tables left out by IteratorOptions.PickTable.
+	BlocksRead    int64 // Table blocks loaded.
+
+	VersionsSkipped int64 // Versions beyond the read timestamp, and versions shadowed by newer ones.
+	DeletedSkipped  int64 // Deleted or expired entries.
+
+	ValueLogReads     int64 // Values read from the value log.
+	ValueLogBytesRead int64 // Bytes read from the value log, including the entry headers.
+}
+
+type blockCounter interface {
+	BlocksRead() int64
+}
+
+// Stats returns the work the iterator has done so far. Values still being prefetched are counted
+// once they've been read.
+func (it *Iterator) Stats() IteratorStats {
+	stats := IteratorStats{
+		TablesPicked:      it.stats.TablesPicked,
+		TablesSkipped:     it.stats.TablesSkipped,
+		VersionsSkipped:   it.stats.VersionsSkipped,
+		DeletedSkipped:    it.stats.DeletedSkipped,
+		ValueLogReads:     atomic.LoadInt64(&it.stats.ValueLogReads),
+		ValueLogBytesRead: atomic.LoadInt64(&it.stats.ValueLogBytesRead),
+	}
+	for _, bc := range it.blockCounters {
+		stats.BlocksRead += bc.BlocksRead()
+	}
+	return stats
+}
+
 // NewIterator returns a new iterator. Depending upon the options, either only keys, or both
 // key-value pairs would be fetched. The keys are returned in lexicographically sorted order.
 // Using prefetch is recommended if you're doing a long running iteration, for performance.
@@ -393,7 +492,7 @@ func (txn *Txn) NewIterator(opt IteratorOptions) *Iterator {
 		panic("Transaction has already been discarded")
 	}
 	// Do not change the order of the next if. We must track the number of running iterators.
-	if atomic.AddInt32(&txn.numIterators, 1) > 1 && txn.update {
+	if !opt.untracked && atomic.AddInt32(&txn.numIterators, 1) > 1 && txn.update {
 		atomic.AddInt32(&txn.numIterators, -1)
 		panic("Only one iterator can be active at one time, for a RW txn.")
 	}
@@ -402,20 +501,42 @@ func (txn *Txn) NewIterator(opt IteratorOptions) *Iterator {
 	// the prefix.
 	tables, decr := txn.db.getMemTables()
 	defer decr()
-	txn.db.vlog.incrIteratorCount()
+	if !opt.KeysOnly {
+		// Keep the value log files around while their values might be read.
+		txn.db.vlog.incrIteratorCount()
+	}
 	var iters []y.Iterator
-	if itr := txn.newPendingWritesIterator(opt.Reverse); itr != nil {
-		iters = append(iters, itr)
+	var spillItrs []*spillIterator
+	if !opt.committedOnly {
+		if itr := txn.newPendingWritesIterator(opt.Reverse); itr != nil {
+			iters = append(iters, itr)
+		}
+		spillItrs = txn.newSpillIterators(opt.Reverse)
+		for _, itr := range spillItrs {
+			iters = append(iters, itr)
+		}
 	}
 	for i := 0; i < len(tables); i++ {
 		iters = append(iters, tables[i].NewUniIterator(opt.Reverse))
 	}
-	iters = txn.db.lc.appendIterators(iters, &opt) // This will increment references.
+	stats := new(IteratorStats)
+	numMemIters := len(iters)
+	iters = txn.db.lc.appendIterators(iters, &opt, stats) // This will increment references.
+	var blockCounters []blockCounter
+	for _, itr := range iters[numMemIters:] {
+		if bc, ok := itr.(blockCounter); ok {
+			blockCounters = append(blockCounters, bc)
+		}
+	}
 	res := &Iterator{
-		txn:    txn,
-		iitr:   y.NewMergeIterator(iters, opt.Reverse),
-		opt:    opt,
-		readTs: txn.readTs,
+		txn:           txn,
+		iitr:          y.NewMergeIterator(iters, opt.Reverse),
+		opt:           opt,
+		readTs:        txn.readTs,
+		reverse:       opt.Reverse,
+		stats:         stats,
+		blockCounters: blockCounters,
+		spillItrs:     spillItrs,
 	}
 	return res
 }
@@ -435,7 +556,13 @@ func (txn *Txn) NewKeyIterator(key []byte, opt IteratorOptions) *Iterator {
 func (it *Iterator) newItem() *Item {
 	item := it.waste.pop()
 	if item == nil {
-		item = &Item{slice: new(y.Slice), db: it.txn.db, txn: it.txn}
+		item = &Item{
+			slice:    new(y.Slice),
+			db:       it.txn.db,
+			txn:      it.txn,
+			keysOnly: it.opt.KeysOnly,
+			stats:    it.stats,
+		}
 	}
 	return item
 }
@@ -450,10 +577,21 @@ func (it *Iterator) Item() *Item {
 
 // Valid returns false when iteration is done.
 func (it *Iterator) Valid() bool {
-	if it.item == nil {
+	if it.item == nil || it.Err() != nil {
 		return false
 	}
-	return bytes.HasPrefix(it.item.key, it.opt.Prefix)
+	return bytes.HasPrefix(it.item.key, it.opt.Prefix) && it.opt.inBounds(it.item.key)
+}
+
+// Err returns the error hit while reading the writes the txn has spilled to disk, if any. See
+// Options.SpillLargeTxns. Once there's an error, the iterator is no longer valid.
+func (it *Iterator) Err() error {
+	for _, itr := range it.spillItrs {
+		if itr.err != nil {
+			return itr.err
+		}
+	}
+	return nil
 }
 
 // ValidForPrefix returns false when iteration is done
@@ -468,6 +606,7 @@ func (it *Iterator) Close() {
 		return
 	}
 	it.closed = true
+	it.trackReadRange()
 
 	it.iitr.Close()
 	// It is important to wait for the fill goroutines to finish. Otherwise, we might leave zombie
@@ -482,14 +621,74 @@ func (it *Iterator) Close() {
 	waitFor(it.waste)
 	waitFor(it.data)
 
-	// TODO: We could handle this error.
-	_ = it.txn.db.vlog.decrIteratorCount()
-	atomic.AddInt32(&it.txn.numIterators, -1)
+	if !it.opt.KeysOnly {
+		// TODO: We could handle this error.
+		_ = it.txn.db.vlog.decrIteratorCount()
+	}
+	if !it.opt.untracked {
+		atomic.AddInt32(&it.txn.numIterators, -1)
+	}
 }
 
 // Next would advance the iterator by one. Always check it.Valid() after a Next()
 // to ensure you have access to a valid it.Item().
 func (it *Iterator) Next() {
+	if it.opt.Reverse != it.reverse {
+		it.switchDirection()
+		return
+	}
+	it.advance()
+}
+
+// Prev would move the iterator back by one, in the opposite direction of Next. It can be called
+// after Next, and the other way around, to change direction mid-iteration. Always check it.Valid()
+// after a Prev() to ensure you have access to a valid it.Item().
+func (it *Iterator) Prev() {
+	if it.opt.Reverse == it.reverse {
+		it.switchDirection()
+		return
+	}
+	it.advance()
+}
+
+// switchDirection turns the iterator around, and moves it to the item next to the current one in
+// the new direction. If the iterator has run off the end, it moves to the first item in the new
+// direction, i.e. the one it had run off from.
+func (it *Iterator) switchDirection() {
+	if it.item == nil {
+		it.seek(nil, !it.opt.Reverse)
+		return
+	}
+	key, version := y.SafeCopy(nil, it.item.Key()), it.item.Version()
+	it.reset(key)
+	it.setDirection(!it.opt.Reverse)
+
+	switch {
+	case it.opt.AllVersions && !it.opt.Reverse:
+		it.iitr.Seek(y.KeyWithTs(key, version-1))
+	case it.opt.AllVersions:
+		it.iitr.Seek(y.KeyWithTs(key, version+1))
+	case !it.opt.Reverse:
+		it.iitr.Seek(y.KeyWithTs(keySuccessor(key), it.txn.readTs))
+	default:
+		// Skips all the versions of key.
+		it.iitr.Seek(y.KeyWithTs(key, math.MaxUint64))
+	}
+	it.prefetch()
+}
+
+// setDirection makes the iterator move in reverse, or forward. The iterator has to be
+// repositioned afterwards.
+func (it *Iterator) setDirection(reverse bool) {
+	if it.opt.Reverse == reverse {
+		return
+	}
+	it.opt.Reverse = reverse
+	it.iitr.SetReversed(reverse)
+}
+
+// advance moves the iterator by one, in its current direction.
+func (it *Iterator) advance() {
 	// Reuse current item
 	it.item.wg.Wait() // Just cleaner to wait before pushing to avoid doing ref counting.
 	it.waste.push(it.item)
@@ -497,7 +696,7 @@ func (it *Iterator) Next() {
 	// Set next item to current
 	it.item = it.data.pop()
 
-	for it.iitr.Valid() {
+	for it.iitr.Valid() && !it.opt.pastBound(y.ParseKey(it.iitr.Key())) {
 		if it.parseItem() {
 			// parseItem calls one extra next.
 			// This is used to deal with the complexity of reverse iteration.
@@ -540,9 +739,17 @@ func (it *Iterator) parseItem() bool {
 		return false
 	}
 
+	// Skip keys outside the bounds. The callers stop at the bound ahead, so these can only be the
+	// keys a Seek landed on, behind the other bound.
+	if !it.opt.inBounds(y.ParseKey(key)) {
+		mi.Next()
+		return false
+	}
+
 	// Skip any versions which are beyond the readTs.
 	version := y.ParseTs(key)
 	if version > it.readTs {
+		it.stats.VersionsSkipped++
 		mi.Next()
 		return false
 	}
@@ -561,6 +768,7 @@ func (it *Iterator) parseItem() bool {
 	// be sufficient.
 	if !it.opt.Reverse {
 		if y.SameKey(it.lastKey, key) {
+			it.stats.VersionsSkipped++
 			mi.Next()
 			return false
 		}
@@ -576,6 +784,7 @@ FILL:
 	// If deleted, advance and return.
 	vs := mi.Value()
 	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+		it.stats.DeletedSkipped++
 		mi.Next()
 		return false
 	}
@@ -595,7 +804,8 @@ FILL:
 	nextTs := y.ParseTs(mi.Key())
 	mik := y.ParseKey(mi.Key())
 	if nextTs <= it.readTs && bytes.Equal(mik, item.key) {
-		// This is a valid potential candidate.
+		// This is a valid potential candidate, which shadows the current one.
+		it.stats.VersionsSkipped++
 		goto FILL
 	}
 	// Ignore the next candidate. Return the current one.
@@ -614,7 +824,7 @@ func (it *Iterator) fill(item *Item) {
 
 	item.vptr = y.SafeCopy(item.vptr, vs.Value)
 	item.val = nil
-	if it.opt.PrefetchValues {
+	if it.opt.PrefetchValues && !it.opt.KeysOnly {
 		item.wg.Add(1)
 		go func() {
 			// FIXME we are not handling errors here.
@@ -626,14 +836,14 @@ func (it *Iterator) fill(item *Item) {
 
 func (it *Iterator) prefetch() {
 	prefetchSize := 2
-	if it.opt.PrefetchValues && it.opt.PrefetchSize > 1 {
+	if it.opt.PrefetchValues && !it.opt.KeysOnly && it.opt.PrefetchSize > 1 {
 		prefetchSize = it.opt.PrefetchSize
 	}
 
 	i := it.iitr
 	var count int
 	it.item = nil
-	for i.Valid() {
+	for i.Valid() && !it.opt.pastBound(y.ParseKey(i.Key())) {
 		if !it.parseItem() {
 			continue
 		}
@@ -648,30 +858,123 @@ func (it *Iterator) prefetch() {
 // greater than the provided key if iterating in the forward direction. Behavior would be reversed if
 // iterating backwards.
 func (it *Iterator) Seek(key []byte) {
-	for i := it.data.pop(); i != nil; i = it.data.pop() {
-		i.wg.Wait()
-		it.waste.push(i)
-	}
+	it.seek(key, it.reverse)
+}
 
-	it.lastKey = it.lastKey[:0]
+// SeekForPrev is like Seek, but for iterating with Prev. It would seek to the provided key if
+// present. If absent, it would seek to the next largest key smaller than the provided key if
+// iterating in the forward direction. Behavior would be reversed if iterating backwards.
+func (it *Iterator) SeekForPrev(key []byte) {
+	it.seek(key, !it.reverse)
+}
+
+func (it *Iterator) seek(key []byte, reverse bool) {
 	if len(key) == 0 {
 		key = it.opt.Prefix
 	}
-	if len(key) == 0 {
+	it.reset(key)
+	it.setDirection(reverse)
+
+	lower, upper := it.opt.LowerBound, it.opt.UpperBound
+	switch {
+	case !it.opt.Reverse && len(lower) > 0 && bytes.Compare(key, lower) < 0:
+		key = y.KeyWithTs(lower, it.txn.readTs)
+	case it.opt.Reverse && len(upper) > 0 && (len(key) == 0 || bytes.Compare(key, upper) >= 0):
+		// Land on the last key below the bound. No version is that high, so UpperBound itself is
+		// excluded.
+		key = y.KeyWithTs(upper, math.MaxUint64)
+	case len(key) == 0:
 		it.iitr.Rewind()
 		it.prefetch()
 		return
-	}
-
-	if !it.opt.Reverse {
+	case !it.opt.Reverse:
 		key = y.KeyWithTs(key, it.txn.readTs)
-	} else {
+	default:
 		key = y.KeyWithTs(key, 0)
 	}
 	it.iitr.Seek(key)
 	it.prefetch()
 }
 
+// reset drops the items fetched so far, before the iterator is repositioned at key.
+func (it *Iterator) reset(key []byte) {
+	it.trackReadRange()
+	if it.item != nil {
+		it.item.wg.Wait()
+		it.waste.push(it.item)
+		it.item = nil
+	}
+	for i := it.data.pop(); i != nil; i = it.data.pop() {
+		i.wg.Wait()
+		it.waste.push(i)
+	}
+
+	it.lastKey = it.lastKey[:0]
+	it.seekKey = y.SafeCopy(it.seekKey, key)
+	it.seeked = true
+}
+
+// trackReadRange registers the range of keys covered since the last Seek with the txn. That goes
+// from the key passed to Seek, up to and including the current key. If the iteration is done, the
+// range extends to the end of the prefix being iterated over, or the end of the keyspace. The range
+// never goes beyond the LowerBound and UpperBound.
+func (it *Iterator) trackReadRange() {
+	if !it.txn.update || !it.seeked {
+		return
+	}
+	it.seeked = false
+
+	var pos []byte // Nil if unbounded.
+	if it.Valid() {
+		pos = it.item.Key()
+	} else if len(it.opt.Prefix) > 0 && !it.opt.Reverse {
+		pos = prefixEnd(it.opt.Prefix)
+	} else if len(it.opt.Prefix) > 0 {
+		pos = it.opt.Prefix
+	}
+
+	var start, end []byte
+	if !it.opt.Reverse {
+		start = y.SafeCopy(nil, it.seekKey)
+		if it.Valid() {
+			end = keySuccessor(pos)
+		} else {
+			end = pos
+		}
+	} else {
+		start = y.SafeCopy(nil, pos)
+		if len(it.seekKey) > 0 {
+			end = keySuccessor(it.seekKey)
+		}
+	}
+
+	if lower := it.opt.LowerBound; len(lower) > 0 && bytes.Compare(start, lower) < 0 {
+		start = y.SafeCopy(nil, lower)
+	}
+	if upper := it.opt.UpperBound; len(upper) > 0 && (end == nil || bytes.Compare(end, upper) > 0) {
+		end = y.SafeCopy(nil, upper)
+	}
+	it.txn.addReadRange(start, end)
+}
+
+// keySuccessor returns the smallest key greater than key.
+func keySuccessor(key []byte) []byte {
+	return append(y.SafeCopy(nil, key), 0)
+}
+
+// prefixEnd returns the smallest key greater than all the keys with the given prefix, or nil if
+// there's no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := y.SafeCopy(nil, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
 // Rewind would rewind the iterator cursor all the way to zero-th position, which would be the
 // smallest key if iterating forward, and largest if iterating backward. It does not keep track of
 // whether the cursor started with a Seek().
@@ -143,6 +143,286 @@ func TestIteratePrefix(t *testing.T) {
 	})
 }
 
+func TestPickTablesBounds(t *testing.T) {
+	opt := DefaultIteratorOptions
+	opt.LowerBound = []byte("b")
+	opt.UpperBound = []byte("d")
+
+	pick := func(left, right string) bool {
+		tm := &tableMock{left: y.KeyWithTs([]byte(left), 1), right: y.KeyWithTs([]byte(right), 1)}
+		return opt.PickTable(tm)
+	}
+	require.True(t, pick("a", "b"))
+	require.True(t, pick("a", "z"))
+	require.True(t, pick("bb", "cc"))
+	require.True(t, pick("c", "z"))
+
+	require.False(t, pick("a", "az"))
+	require.False(t, pick("d", "z"))
+	require.False(t, pick("da", "e"))
+}
+
+func TestIterateBounds(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		bkey := func(i int) []byte {
+			return []byte(fmt.Sprintf("%04d", i))
+		}
+		batch := db.NewWriteBatch()
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, batch.Set(bkey(i), []byte("OK"), 0))
+		}
+		require.NoError(t, batch.Flush())
+
+		keys := func(txn *Txn, opt IteratorOptions, seek []byte) []string {
+			itr := txn.NewIterator(opt)
+			defer itr.Close()
+			var keys []string
+			for itr.Seek(seek); itr.Valid(); itr.Next() {
+				keys = append(keys, string(itr.Item().Key()))
+			}
+			return keys
+		}
+		check := func(txn *Txn) {
+			opt := DefaultIteratorOptions
+			opt.LowerBound = bkey(100)
+			opt.UpperBound = bkey(105)
+			require.Equal(t, []string{"0100", "0101", "0102", "0103", "0104"}, keys(txn, opt, nil))
+			require.Equal(t, []string{"0100", "0101", "0102", "0103", "0104"}, keys(txn, opt, bkey(5)))
+			require.Equal(t, []string{"0103", "0104"}, keys(txn, opt, bkey(103)))
+			require.Empty(t, keys(txn, opt, bkey(500)))
+
+			opt.Reverse = true
+			require.Equal(t, []string{"0104", "0103", "0102", "0101", "0100"}, keys(txn, opt, nil))
+			require.Equal(t, []string{"0104", "0103", "0102", "0101", "0100"}, keys(txn, opt, bkey(500)))
+			require.Equal(t, []string{"0101", "0100"}, keys(txn, opt, bkey(101)))
+			require.Empty(t, keys(txn, opt, bkey(5)))
+
+			// Bounds combine with the prefix.
+			opt = DefaultIteratorOptions
+			opt.Prefix = []byte("010")
+			opt.UpperBound = bkey(103)
+			require.Equal(t, []string{"0100", "0101", "0102"}, keys(txn, opt, nil))
+		}
+		require.NoError(t, db.View(func(txn *Txn) error {
+			check(txn)
+			return nil
+		}))
+
+		// Pending writes are bounded too.
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set(bkey(105), []byte("OK")))
+		require.NoError(t, txn.Delete(bkey(99)))
+		check(txn)
+	})
+}
+
+func TestIteratePrev(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		bkey := func(i int) []byte {
+			return []byte(fmt.Sprintf("%04d", i))
+		}
+		batch := db.NewWriteBatch()
+		for i := 0; i < 100; i += 2 {
+			require.NoError(t, batch.Set(bkey(i), []byte("OK"), 0))
+		}
+		require.NoError(t, batch.Flush())
+		// A second version of each key, which only shows up with AllVersions.
+		batch = db.NewWriteBatch()
+		for i := 0; i < 100; i += 2 {
+			require.NoError(t, batch.Set(bkey(i), []byte("OK"), 0))
+		}
+		require.NoError(t, batch.Flush())
+
+		check := func(txn *Txn, reverse bool) {
+			opt := DefaultIteratorOptions
+			opt.Reverse = reverse
+			itr := txn.NewIterator(opt)
+			defer itr.Close()
+			key := func() string {
+				require.True(t, itr.Valid())
+				return string(itr.Item().Key())
+			}
+			next, prev := "0012", "0008"
+			if reverse {
+				next, prev = prev, next
+			}
+
+			itr.Seek(bkey(10))
+			require.Equal(t, "0010", key())
+			itr.Next()
+			require.Equal(t, next, key())
+			itr.Prev()
+			require.Equal(t, "0010", key())
+			itr.Prev()
+			require.Equal(t, prev, key())
+			itr.Next()
+			require.Equal(t, "0010", key())
+
+			at, before := "0010", "0008"
+			if reverse {
+				at, before = "0012", "0014"
+			}
+			itr.SeekForPrev(bkey(11))
+			require.Equal(t, at, key())
+			itr.Prev()
+			require.Equal(t, before, key())
+
+			itr.Rewind()
+			var n int
+			for ; itr.Valid(); itr.Next() {
+				n++
+			}
+			require.Equal(t, 50, n)
+
+			// Having run off the end, Prev comes back to the last key.
+			last := "0098"
+			if reverse {
+				last = "0000"
+			}
+			itr.Prev()
+			require.Equal(t, last, key())
+			itr.Next()
+			require.False(t, itr.Valid())
+		}
+		require.NoError(t, db.View(func(txn *Txn) error {
+			check(txn, false)
+			check(txn, true)
+			return nil
+		}))
+
+		// Pending writes, including a delete, are taken into account in both directions.
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set(bkey(9), []byte("OK")))
+		require.NoError(t, txn.Delete(bkey(8)))
+		itr := txn.NewIterator(DefaultIteratorOptions)
+		itr.Seek(bkey(10))
+		itr.Prev()
+		require.Equal(t, "0009", string(itr.Item().Key()))
+		itr.Prev()
+		require.Equal(t, "0006", string(itr.Item().Key()))
+		itr.Next()
+		require.Equal(t, "0009", string(itr.Item().Key()))
+		itr.Next()
+		require.Equal(t, "0010", string(itr.Item().Key()))
+		itr.Close()
+
+		// With AllVersions, Prev steps through the versions of a key too.
+		require.NoError(t, db.View(func(txn *Txn) error {
+			opt := DefaultIteratorOptions
+			opt.AllVersions = true
+			itr := txn.NewIterator(opt)
+			defer itr.Close()
+			itr.Seek(bkey(10))
+			first := itr.Item().Version()
+			itr.Next()
+			require.Equal(t, "0010", string(itr.Item().Key()))
+			require.True(t, itr.Item().Version() < first)
+			itr.Prev()
+			require.Equal(t, "0010", string(itr.Item().Key()))
+			require.Equal(t, first, itr.Item().Version())
+			itr.Prev()
+			require.Equal(t, "0008", string(itr.Item().Key()))
+			return nil
+		}))
+	})
+}
+
+func TestIterateKeysOnly(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		small, big := []byte("OK"), make([]byte, 1<<10) // The big one goes to the value log.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			if err := txn.Set([]byte("a"), small); err != nil {
+				return err
+			}
+			return txn.Set([]byte("b"), big)
+		}))
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			opt := DefaultIteratorOptions
+			opt.KeysOnly = true
+			itr := txn.NewIterator(opt)
+			defer itr.Close()
+			require.Equal(t, 0, db.vlog.iteratorCount())
+
+			var sizes []int64
+			for itr.Rewind(); itr.Valid(); itr.Next() {
+				item := itr.Item()
+				sizes = append(sizes, item.ValueSize())
+				require.Equal(t, ErrKeysOnly, item.Value(func(v []byte) error { return nil }))
+				_, err := item.ValueCopy(nil)
+				require.Equal(t, ErrKeysOnly, err)
+			}
+			require.Equal(t, []int64{int64(len(small)), int64(len(big))}, sizes)
+			return nil
+		}))
+	})
+}
+
+func TestIteratorStats(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+
+	set := func(key string, val []byte) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte(key), val)
+		}))
+	}
+	set("a", []byte("a1"))
+	set("a", []byte("a2"))
+	set("b", []byte("b1"))
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Delete([]byte("b"))
+	}))
+	set("c", make([]byte, 1<<10)) // Goes to the value log.
+	// Reopen, so that the memtable gets flushed to a table.
+	require.NoError(t, db.Close())
+	db, err = Open(getTestOptions(dir))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		itr := txn.NewIterator(IteratorOptions{})
+		defer itr.Close()
+		var keys []string
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			item := itr.Item()
+			keys = append(keys, string(item.Key()))
+			_, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+		}
+		require.Equal(t, []string{"a", "c"}, keys)
+
+		stats := itr.Stats()
+		require.True(t, stats.TablesPicked > 0)
+		require.Zero(t, stats.TablesSkipped)
+		require.True(t, stats.BlocksRead > 0)
+		require.Equal(t, int64(2), stats.VersionsSkipped) // a1 and b1.
+		require.Equal(t, int64(1), stats.DeletedSkipped)
+		require.Equal(t, int64(1), stats.ValueLogReads)
+		require.True(t, stats.ValueLogBytesRead > 1<<10)
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		itr := txn.NewIterator(IteratorOptions{LowerBound: []byte("d")})
+		defer itr.Close()
+		itr.Rewind()
+		require.False(t, itr.Valid())
+
+		stats := itr.Stats()
+		require.Zero(t, stats.TablesPicked)
+		require.True(t, stats.TablesSkipped > 0)
+		require.Zero(t, stats.BlocksRead)
+		require.Zero(t, stats.ValueLogReads)
+		return nil
+	}))
+}
+
 // go test -v -run=XXX -bench=BenchmarkIterate -benchtime=3s
 // Benchmark with opt.Prefix set ===
 // goos: linux
@@ -242,3 +522,47 @@ func BenchmarkIteratePrefixSingleKey(b *testing.B) {
 		}
 	})
 }
+
+// go test -v -run=XXX -bench=BenchmarkIterateKeysOnly
+func BenchmarkIterateKeysOnly(b *testing.B) {
+	dir, err := ioutil.TempDir(".", "badger-test")
+	y.Check(err)
+	defer os.RemoveAll(dir)
+	db, err := Open(getTestOptions(dir))
+	y.Check(err)
+	defer db.Close()
+
+	N := 100000
+	val := make([]byte, 100) // Stored in the value log.
+	batch := db.NewWriteBatch()
+	for i := 0; i < N; i++ {
+		y.Check(batch.Set([]byte(fmt.Sprintf("%06d", i)), val, 0))
+	}
+	y.Check(batch.Flush())
+
+	count := func(b *testing.B, opt IteratorOptions) {
+		for i := 0; i < b.N; i++ {
+			var count int
+			err := db.View(func(txn *Txn) error {
+				itr := txn.NewIterator(opt)
+				defer itr.Close()
+				for itr.Rewind(); itr.Valid(); itr.Next() {
+					count++
+				}
+				return nil
+			})
+			y.Check(err)
+			if count != N {
+				b.Fatalf("Expected %d keys. Found: %d", N, count)
+			}
+		}
+	}
+	b.Run("Default", func(b *testing.B) {
+		count(b, DefaultIteratorOptions)
+	})
+	b.Run("KeysOnly", func(b *testing.B) {
+		opt := DefaultIteratorOptions
+		opt.KeysOnly = true
+		count(b, opt)
+	})
+}
@@ -100,50 +100,38 @@ func (s *levelHandler) deleteTables(toDel []*table.Table) error {
 	return decrRefs(toDel)
 }
 
-// replaceTables will replace tables[left:right] with newTables. Note this EXCLUDES tables[right].
+// replaceTables will replace the tables in toDel with those in toAdd. The tables in toDel can be
+// anywhere in the level, and needn't be covered by the key range of toAdd, which matters when the
+// compaction dropped every key of some of them.
 // You must call decr() to delete the old tables _after_ writing the update to the manifest.
-func (s *levelHandler) replaceTables(newTables []*table.Table) error {
-	// Need to re-search the range of tables in this level to be replaced as other goroutines might
-	// be changing it as well.  (They can't touch our tables, but if they add/remove other tables,
-	// the indices get shifted around.)
-	if len(newTables) == 0 {
-		return nil
-	}
-
+func (s *levelHandler) replaceTables(toDel, toAdd []*table.Table) error {
 	s.Lock() // We s.Unlock() below.
 
-	// Increase totalSize first.
-	for _, tbl := range newTables {
-		s.totalSize += tbl.Size()
-		tbl.IncrRef()
+	toDelMap := make(map[uint64]struct{})
+	for _, t := range toDel {
+		toDelMap[t.ID()] = struct{}{}
 	}
 
-	kr := keyRange{
-		left:  newTables[0].Smallest(),
-		right: newTables[len(newTables)-1].Biggest(),
+	// To be safe, just make a copy. TODO: Be more careful and avoid copying.
+	var newTables []*table.Table
+	for _, t := range s.tables {
+		if _, found := toDelMap[t.ID()]; !found {
+			newTables = append(newTables, t)
+			continue
+		}
+		s.totalSize -= t.Size()
 	}
-	left, right := s.overlappingTables(levelHandlerRLocked{}, kr)
-
-	toDecr := make([]*table.Table, right-left)
-	// Update totalSize and reference counts.
-	for i := left; i < right; i++ {
-		tbl := s.tables[i]
-		s.totalSize -= tbl.Size()
-		toDecr[i-left] = tbl
+	for _, t := range toAdd {
+		s.totalSize += t.Size()
+		t.IncrRef()
+		newTables = append(newTables, t)
 	}
-
-	// To be safe, just make a copy. TODO: Be more careful and avoid copying.
-	numDeleted := right - left
-	numAdded := len(newTables)
-	tables := make([]*table.Table, len(s.tables)-numDeleted+numAdded)
-	y.AssertTrue(left == copy(tables, s.tables[:left]))
-	t := tables[left:]
-	y.AssertTrue(numAdded == copy(t, newTables))
-	t = t[numAdded:]
-	y.AssertTrue(len(s.tables[right:]) == copy(t, s.tables[right:]))
-	s.tables = tables
+	sort.Slice(newTables, func(i, j int) bool {
+		return y.CompareKeys(newTables[i].Smallest(), newTables[j].Smallest()) < 0
+	})
+	s.tables = newTables
 	s.Unlock() // s.Unlock before we DecrRef tables -- that can be slow.
-	return decrRefs(toDecr)
+	return decrRefs(toDel)
 }
 
 func decrRefs(tables []*table.Table) error {
@@ -264,9 +252,110 @@ func (s *levelHandler) get(key []byte) (y.ValueStruct, error) {
 	return maxVs, decr()
 }
 
+// tableKeys is a table, along with the keys to look up in it.
+type tableKeys struct {
+	t    *table.Table
+	keys []int // Indices of the keys, in ascending order.
+}
+
+// getTablesForKeys is like getTableForKey, for the sorted keys which aren't done yet.
+func (s *levelHandler) getTablesForKeys(keys [][]byte, done []bool) ([]tableKeys, func() error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var out []tableKeys
+	if s.level == 0 {
+		// All the keys have to be looked up in every table, newest first.
+		var idx []int
+		for i := range keys {
+			if !done[i] {
+				idx = append(idx, i)
+			}
+		}
+		if len(idx) > 0 {
+			for i := len(s.tables) - 1; i >= 0; i-- {
+				out = append(out, tableKeys{t: s.tables[i], keys: idx})
+				s.tables[i].IncrRef()
+			}
+		}
+	} else {
+		for i, key := range keys {
+			if done[i] {
+				continue
+			}
+			idx := sort.Search(len(s.tables), func(j int) bool {
+				return y.CompareKeys(s.tables[j].Biggest(), key) >= 0
+			})
+			if idx >= len(s.tables) {
+				break // Keys are sorted, so the rest are beyond this level too.
+			}
+			// Keys are sorted, so the keys in the same table are next to each other.
+			if tbl := s.tables[idx]; len(out) == 0 || out[len(out)-1].t != tbl {
+				out = append(out, tableKeys{t: tbl})
+				tbl.IncrRef()
+			}
+			out[len(out)-1].keys = append(out[len(out)-1].keys, i)
+		}
+	}
+	return out, func() error {
+		for _, tk := range out {
+			if err := tk.t.DecrRef(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// getMany is like get, for a batch of sorted keys. It looks up the keys which aren't done yet,
+// using one table iterator per table, and marks the ones found in this level as done.
+func (s *levelHandler) getMany(keys [][]byte, vs []y.ValueStruct, done []bool) error {
+	tables, decr := s.getTablesForKeys(keys, done)
+
+	var found []int
+	for _, tk := range tables {
+		var it *table.Iterator
+		for _, i := range tk.keys {
+			key := keys[i]
+			if tk.t.DoesNotHave(y.ParseKey(key)) {
+				y.NumLSMBloomHits.Add(s.strLevel, 1)
+				continue
+			}
+			if it == nil {
+				it = tk.t.NewIterator(false)
+			}
+
+			y.NumLSMGets.Add(s.strLevel, 1)
+			it.Seek(key)
+			if !it.Valid() || !y.SameKey(key, it.Key()) {
+				continue
+			}
+			if version := y.ParseTs(it.Key()); vs[i].Version < version {
+				if vs[i].Version == 0 {
+					found = append(found, i)
+				}
+				vs[i] = it.Value()
+				vs[i].Version = version
+			}
+		}
+		if it != nil {
+			if err := it.Close(); err != nil {
+				_ = decr()
+				return err
+			}
+		}
+	}
+	for _, i := range found {
+		done[i] = true
+	}
+	return decr()
+}
+
 // appendIterators appends iterators to an array of iterators, for merging.
+// The tables picked and skipped are counted in stats.
 // Note: This obtains references for the table handlers. Remember to close these iterators.
-func (s *levelHandler) appendIterators(iters []y.Iterator, opt *IteratorOptions) []y.Iterator {
+func (s *levelHandler) appendIterators(
+	iters []y.Iterator, opt *IteratorOptions, stats *IteratorStats) []y.Iterator {
 	s.RLock()
 	defer s.RUnlock()
 
@@ -276,6 +365,8 @@ func (s *levelHandler) appendIterators(iters []y.Iterator, opt *IteratorOptions)
 			tables = append(tables, t)
 		}
 	}
+	stats.TablesPicked += int64(len(tables))
+	stats.TablesSkipped += int64(len(s.tables) - len(tables))
 	if len(tables) == 0 {
 		return iters
 	}
@@ -17,6 +17,7 @@
 package badger
 
 import (
+	"bytes"
 	"fmt"
 	"math"
 	"math/rand"
@@ -26,6 +27,7 @@ import (
 
 	"golang.org/x/net/trace"
 
+	"github.com/dgraph-io/badger/options"
 	"github.com/dgraph-io/badger/pb"
 	"github.com/dgraph-io/badger/table"
 	"github.com/dgraph-io/badger/y"
@@ -236,6 +238,7 @@ func (s *levelsController) runWorker(lc *y.Closer) {
 		select {
 		// Can add a done channel or other stuff.
 		case <-ticker.C:
+			s.kv.tuneCompactionRate()
 			prios := s.pickCompactLevels()
 			for _, p := range prios {
 				if err := s.doCompact(p); err == nil {
@@ -264,8 +267,9 @@ func (l *levelHandler) isCompactable(delSize int64) bool {
 }
 
 type compactionPriority struct {
-	level int
-	score float64
+	level   int
+	score   float64
+	garbage bool // Compact the tables with the most garbage, instead of the biggest ones.
 }
 
 // pickCompactLevel determines which level to compact.
@@ -282,6 +286,10 @@ func (s *levelsController) pickCompactLevels() (prios []compactionPriority) {
 		}
 		prios = append(prios, pri)
 	}
+	if s.kv.opt.CompactionStyle == options.TieredCompaction {
+		// Tiered compactions always start from level 0. See fillTablesTiered.
+		return prios
+	}
 
 	for i, l := range s.levels[1:] {
 		// Don't consider those tables that are already being compacted right now.
@@ -293,6 +301,14 @@ func (s *levelsController) pickCompactLevels() (prios []compactionPriority) {
 				score: float64(l.getTotalSize()-delSize) / float64(l.maxTotalSize),
 			}
 			prios = append(prios, pri)
+		} else if i+1 < len(s.levels)-1 {
+			// The level is within its size target, but might still have tables full of delete
+			// markers and expired keys, which slow down reads. The last level is left out, because
+			// there's no level below it to compact into.
+			if ratio := s.maxGarbageRatio(l); ratio > s.kv.opt.CompactionGarbageRatio {
+				pri := compactionPriority{level: i + 1, score: ratio, garbage: true}
+				prios = append(prios, pri)
+			}
 		}
 	}
 	sort.Slice(prios, func(i, j int) bool {
@@ -301,6 +317,78 @@ func (s *levelsController) pickCompactLevels() (prios []compactionPriority) {
 	return prios
 }
 
+// maxGarbageRatio returns the highest garbage ratio of any table in the level, which isn't already
+// being compacted. It returns zero if CompactionGarbageRatio is not set.
+func (s *levelsController) maxGarbageRatio(l *levelHandler) float64 {
+	if s.kv.opt.CompactionGarbageRatio <= 0 {
+		return 0
+	}
+	now := uint64(time.Now().Unix())
+	l.RLock()
+	defer l.RUnlock()
+	var max float64
+	for _, t := range l.tables {
+		if ratio := t.Stats().GarbageRatio(now); ratio > max {
+			kr := keyRange{
+				left:  y.KeyWithTs(y.ParseKey(t.Smallest()), math.MaxUint64),
+				right: y.KeyWithTs(y.ParseKey(t.Biggest()), 0),
+			}
+			if !s.cstatus.overlapsWith(l.level, kr) {
+				max = ratio
+			}
+		}
+	}
+	return max
+}
+
+// subcompactionRanges divides the key range covered by cd into at most NumSubcompactions
+// disjoint ranges, using the block boundaries of the tables involved as split points. Each range
+// is [left, right) over keys without timestamps, where an empty left or right is unbounded. This
+// ensures that all the versions of a key fall within the same range.
+func (s *levelsController) subcompactionRanges(l int, cd compactDef) []keyRange {
+	n := s.kv.opt.NumSubcompactions
+	if l != 0 || n <= 1 {
+		return []keyRange{{}}
+	}
+
+	var keys [][]byte
+	collect := func(tables []*table.Table) {
+		for _, t := range tables {
+			for _, k := range t.BlockKeys() {
+				keys = append(keys, y.ParseKey(k))
+			}
+		}
+	}
+	collect(cd.top)
+	for _, tables := range cd.mid {
+		collect(tables)
+	}
+	collect(cd.bot)
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	// Remove duplicates, so every split point lies on a distinct key.
+	uniq := keys[:0]
+	for _, k := range keys {
+		if len(uniq) == 0 || !bytes.Equal(uniq[len(uniq)-1], k) {
+			uniq = append(uniq, k)
+		}
+	}
+	keys = uniq
+	if len(keys) < n {
+		n = len(keys)
+	}
+
+	var ranges []keyRange
+	var left []byte
+	for i := 1; i < n; i++ {
+		right := keys[i*len(keys)/n]
+		ranges = append(ranges, keyRange{left: left, right: right})
+		left = right
+	}
+	return append(ranges, keyRange{left: left})
+}
+
 // compactBuildTables merge topTables and botTables to form a list of new tables.
 func (s *levelsController) compactBuildTables(
 	l int, cd compactDef) ([]*table.Table, func() error, error) {
@@ -309,9 +397,17 @@ func (s *levelsController) compactBuildTables(
 
 	var hasOverlap bool
 	{
-		kr := getKeyRange(cd.top)
+		var kr keyRange
+		skip := l
+		if s.kv.opt.CompactionStyle == options.TieredCompaction {
+			// Tiered compactions merge whole levels, so only the levels below the output matter.
+			kr = getKeyRange(cd.allTables())
+			skip = cd.nextLevel.level
+		} else {
+			kr = getKeyRange(cd.top)
+		}
 		for i, lh := range s.levels {
-			if i <= l { // Skip upper levels.
+			if i <= skip { // Skip upper levels.
 				continue
 			}
 			lh.RLock()
@@ -325,8 +421,97 @@ func (s *levelsController) compactBuildTables(
 		cd.elog.LazyPrintf("Key range overlaps with lower levels: %v", hasOverlap)
 	}
 
+	// Create iterators across all the tables involved. Every subcompaction needs its own set,
+	// because iterators can't be shared across goroutines.
+	newIterator := func() y.Iterator {
+		var iters []y.Iterator
+		if l == 0 {
+			iters = appendIteratorsReversed(iters, topTables, false)
+		} else {
+			y.AssertTrue(len(topTables) == 1)
+			iters = []y.Iterator{topTables[0].NewIterator(false)}
+		}
+
+		// Next level has level>=1 and we can use ConcatIterator as key ranges do not overlap. The
+		// same goes for the levels in between, merged by tiered compactions.
+		for _, tables := range cd.mid {
+			iters = append(iters, table.NewConcatIterator(tables, false))
+		}
+		iters = append(iters, table.NewConcatIterator(botTables, false))
+		return y.NewMergeIterator(iters, false)
+	}
+
+	// Pick a discard ts, so we can discard versions below this ts. We should
+	// never discard any versions starting from above this timestamp, because
+	// that would affect the snapshot view guarantee provided by transactions.
+	discardTs := s.kv.orc.discardAtOrBelow()
+
+	ranges := s.subcompactionRanges(l, cd)
+	cd.elog.LazyPrintf("Running %d subcompaction(s)", len(ranges))
+
+	type subcompactResult struct {
+		tables       []*table.Table
+		discardStats map[uint32]int64
+		err          error
+	}
+	resultCh := make(chan subcompactResult, len(ranges))
+	for _, kr := range ranges {
+		go func(kr keyRange) {
+			it := newIterator()
+			defer it.Close() // Important to close the iterator to do ref counting.
+
+			tables, stats, err := s.subcompact(it, kr, cd, hasOverlap, discardTs)
+			resultCh <- subcompactResult{tables: tables, discardStats: stats, err: err}
+		}(kr)
+	}
+
+	newTables := make([]*table.Table, 0, 20)
 	// Try to collect stats so that we can inform value log about GC. That would help us find which
 	// value log file should be GCed.
+	discardStats := make(map[uint32]int64)
+	// Wait for all subcompactions to finish.
+	var firstErr error
+	for range ranges {
+		res := <-resultCh
+		newTables = append(newTables, res.tables...)
+		for fid, sz := range res.discardStats {
+			discardStats[fid] += sz
+		}
+		if firstErr == nil {
+			firstErr = res.err
+		}
+	}
+
+	if firstErr == nil {
+		// Ensure created files' directory entries are visible.  We don't mind the extra latency
+		// from not doing this ASAP after all file creation has finished because this is a
+		// background operation.
+		firstErr = syncDir(s.kv.opt.Dir)
+	}
+
+	if firstErr != nil {
+		// An error happened.  Delete all the newly created table files (by calling DecrRef
+		// -- we're the only holders of a ref).
+		for _, tbl := range newTables {
+			tbl.DecrRef()
+		}
+		errorReturn := errors.Wrapf(firstErr, "While running compaction for: %+v", cd)
+		return nil, nil, errorReturn
+	}
+
+	sort.Slice(newTables, func(i, j int) bool {
+		return y.CompareKeys(newTables[i].Biggest(), newTables[j].Biggest()) < 0
+	})
+	s.kv.vlog.updateGCStats(discardStats)
+	cd.elog.LazyPrintf("Discard stats: %v", discardStats)
+	return newTables, func() error { return decrRefs(newTables) }, nil
+}
+
+// subcompact merges the keys within kr from it into new tables. It returns the tables which were
+// successfully created, even if it encounters an error, so the caller can clean them up.
+func (s *levelsController) subcompact(it y.Iterator, kr keyRange, cd compactDef,
+	hasOverlap bool, discardTs uint64) ([]*table.Table, map[uint32]int64, error) {
+
 	discardStats := make(map[uint32]int64)
 	updateStats := func(vs y.ValueStruct) {
 		if vs.Meta&bitValuePointer > 0 {
@@ -336,26 +521,18 @@ func (s *levelsController) compactBuildTables(
 		}
 	}
 
-	// Create iterators across all the tables involved first.
-	var iters []y.Iterator
-	if l == 0 {
-		iters = appendIteratorsReversed(iters, topTables, false)
+	if len(kr.left) > 0 {
+		it.Seek(y.KeyWithTs(kr.left, math.MaxUint64))
 	} else {
-		y.AssertTrue(len(topTables) == 1)
-		iters = []y.Iterator{topTables[0].NewIterator(false)}
+		it.Rewind()
+	}
+	// valid returns false once we run out of keys, or move past the end of kr.
+	valid := func() bool {
+		if !it.Valid() {
+			return false
+		}
+		return len(kr.right) == 0 || bytes.Compare(y.ParseKey(it.Key()), kr.right) < 0
 	}
-
-	// Next level has level>=1 and we can use ConcatIterator as key ranges do not overlap.
-	iters = append(iters, table.NewConcatIterator(botTables, false))
-	it := y.NewMergeIterator(iters, false)
-	defer it.Close() // Important to close the iterator to do ref counting.
-
-	it.Rewind()
-
-	// Pick a discard ts, so we can discard versions below this ts. We should
-	// never discard any versions starting from above this timestamp, because
-	// that would affect the snapshot view guarantee provided by transactions.
-	discardTs := s.kv.orc.discardAtOrBelow()
 
 	// Start generating new tables.
 	type newTableResult struct {
@@ -365,11 +542,11 @@ func (s *levelsController) compactBuildTables(
 	resultCh := make(chan newTableResult)
 	var numBuilds, numVersions int
 	var lastKey, skipKey []byte
-	for it.Valid() {
+	for valid() {
 		timeStart := time.Now()
 		builder := table.NewTableBuilder()
 		var numKeys, numSkips uint64
-		for ; it.Valid(); it.Next() {
+		for ; valid(); it.Next() {
 			// See if we need to skip this key.
 			if len(skipKey) > 0 {
 				if y.SameKey(it.Key(), skipKey) {
@@ -423,7 +600,7 @@ func (s *levelsController) compactBuildTables(
 				}
 			}
 			numKeys++
-			y.Check(builder.Add(it.Key(), it.Value()))
+			y.Check(builder.Add(it.Key(), vs, vs.Meta&bitDelete > 0))
 		}
 		// It was true that it.Valid() at least once in the loop above, which means we
 		// called Add() at least once, and builder is not Empty().
@@ -441,10 +618,12 @@ func (s *levelsController) compactBuildTables(
 					return
 				}
 
-				if _, err := fd.Write(builder.Finish()); err != nil {
+				data := builder.Finish()
+				if err := writeRateLimited(fd, data, s.kv.rateLimiter, s.kv.compactionRateLimiter); err != nil {
 					resultCh <- newTableResult{nil, errors.Wrapf(err, "Unable to write to file: %d", fileID)}
 					return
 				}
+				y.NumCompactionBytesWritten.Add(int64(len(data)))
 
 				tbl, err := table.OpenTable(fd, s.kv.opt.TableLoadingMode)
 				// decrRef is added below.
@@ -453,42 +632,19 @@ func (s *levelsController) compactBuildTables(
 		}
 	}
 
-	newTables := make([]*table.Table, 0, 20)
+	var newTables []*table.Table
 	// Wait for all table builders to finish.
 	var firstErr error
 	for x := 0; x < numBuilds; x++ {
 		res := <-resultCh
-		newTables = append(newTables, res.table)
+		if res.table != nil {
+			newTables = append(newTables, res.table)
+		}
 		if firstErr == nil {
 			firstErr = res.err
 		}
 	}
-
-	if firstErr == nil {
-		// Ensure created files' directory entries are visible.  We don't mind the extra latency
-		// from not doing this ASAP after all file creation has finished because this is a
-		// background operation.
-		firstErr = syncDir(s.kv.opt.Dir)
-	}
-
-	if firstErr != nil {
-		// An error happened.  Delete all the newly created table files (by calling DecrRef
-		// -- we're the only holders of a ref).
-		for j := 0; j < numBuilds; j++ {
-			if newTables[j] != nil {
-				newTables[j].DecrRef()
-			}
-		}
-		errorReturn := errors.Wrapf(firstErr, "While running compaction for: %+v", cd)
-		return nil, nil, errorReturn
-	}
-
-	sort.Slice(newTables, func(i, j int) bool {
-		return y.CompareKeys(newTables[i].Biggest(), newTables[j].Biggest()) < 0
-	})
-	s.kv.vlog.updateGCStats(discardStats)
-	cd.elog.LazyPrintf("Discard stats: %v", discardStats)
-	return newTables, func() error { return decrRefs(newTables) }, nil
+	return newTables, discardStats, firstErr
 }
 
 func buildChangeSet(cd *compactDef, newTables []*table.Table) pb.ManifestChangeSet {
@@ -499,6 +655,11 @@ func buildChangeSet(cd *compactDef, newTables []*table.Table) pb.ManifestChangeS
 	for _, table := range cd.top {
 		changes = append(changes, makeTableDeleteChange(table.ID()))
 	}
+	for _, tables := range cd.mid {
+		for _, table := range tables {
+			changes = append(changes, makeTableDeleteChange(table.ID()))
+		}
+	}
 	for _, table := range cd.bot {
 		changes = append(changes, makeTableDeleteChange(table.ID()))
 	}
@@ -518,6 +679,21 @@ type compactDef struct {
 	nextRange keyRange
 
 	thisSize int64
+
+	garbage bool // Picked for the garbage in top, so it needs to be rewritten.
+
+	// Tiered compactions also merge the whole levels in between thisLevel and nextLevel, in order.
+	midLevels []*levelHandler
+	mid       [][]*table.Table
+}
+
+// allTables returns all the tables being compacted.
+func (cd *compactDef) allTables() []*table.Table {
+	tables := append([]*table.Table{}, cd.top...)
+	for _, mid := range cd.mid {
+		tables = append(tables, mid...)
+	}
+	return append(tables, cd.bot...)
 }
 
 func (cd *compactDef) lockLevels() {
@@ -569,11 +745,28 @@ func (s *levelsController) fillTables(cd *compactDef) bool {
 		return false
 	}
 
-	// Find the biggest table, and compact that first.
-	// TODO: Try other table picking strategies.
-	sort.Slice(tbls, func(i, j int) bool {
-		return tbls[i].Size() > tbls[j].Size()
-	})
+	if cd.garbage {
+		// Only pick the tables over the garbage threshold, starting with the one with the most.
+		now := uint64(time.Now().Unix())
+		ratios := make(map[uint64]float64)
+		filtered := tbls[:0]
+		for _, t := range tbls {
+			if ratio := t.Stats().GarbageRatio(now); ratio > s.kv.opt.CompactionGarbageRatio {
+				ratios[t.ID()] = ratio
+				filtered = append(filtered, t)
+			}
+		}
+		tbls = filtered
+		sort.Slice(tbls, func(i, j int) bool {
+			return ratios[tbls[i].ID()] > ratios[tbls[j].ID()]
+		})
+	} else {
+		// Find the biggest table, and compact that first.
+		// TODO: Try other table picking strategies.
+		sort.Slice(tbls, func(i, j int) bool {
+			return tbls[i].Size() > tbls[j].Size()
+		})
+	}
 
 	for _, t := range tbls {
 		cd.thisSize = t.Size()
@@ -613,14 +806,117 @@ func (s *levelsController) fillTables(cd *compactDef) bool {
 	return false
 }
 
+// fillTablesTiered picks the tables for a tiered compaction. Every level other than level 0 holds a
+// single sorted run, with newer runs in lower levels. All of level 0 gets merged with the newest
+// runs of a similar size, and the result is written to the level of the oldest run picked. If no
+// run is picked, the result becomes a new run right above the newest one. If full is set, or the
+// newer runs have grown too big compared to the oldest one, all runs get merged together.
+func (s *levelsController) fillTablesTiered(cd *compactDef, full bool) bool {
+	// Any level might take part, so keep all of them unchanged while picking.
+	for _, lh := range s.levels {
+		lh.RLock()
+	}
+	defer func() {
+		for _, lh := range s.levels {
+			lh.RUnlock()
+		}
+	}()
+
+	cd.top = make([]*table.Table, len(cd.thisLevel.tables))
+	copy(cd.top, cd.thisLevel.tables)
+
+	var runs []*levelHandler
+	for _, lh := range s.levels[1:] {
+		if len(lh.tables) > 0 {
+			runs = append(runs, lh)
+		}
+	}
+	if len(cd.top) == 0 && (!full || len(runs) <= 1) {
+		return false
+	}
+
+	size := cd.thisLevel.totalSize
+	if len(runs) > 0 {
+		newer := size
+		for _, r := range runs[:len(runs)-1] {
+			newer += r.totalSize
+		}
+		oldest := runs[len(runs)-1].totalSize
+		if newer*100 > oldest*int64(s.kv.opt.TieredMaxSizeAmplification) {
+			full = true
+		}
+	}
+
+	var n int // Number of runs to merge.
+	if full {
+		n = len(runs)
+	} else {
+		for _, r := range runs {
+			// If the newest run is in level 1, there's no free level above it to write the result
+			// to, so it has to be merged.
+			forced := n == 0 && r.level == 1
+			if !forced && r.totalSize*100 > size*int64(100+s.kv.opt.TieredSizeRatio) {
+				break
+			}
+			size += r.totalSize
+			n++
+		}
+	}
+
+	if n == 0 {
+		cd.nextLevel = s.levels[len(s.levels)-1]
+		if len(runs) > 0 {
+			cd.nextLevel = s.levels[runs[0].level-1]
+		}
+	} else {
+		cd.nextLevel = runs[n-1]
+		cd.bot = make([]*table.Table, len(cd.nextLevel.tables))
+		copy(cd.bot, cd.nextLevel.tables)
+		for _, r := range runs[:n-1] {
+			tables := make([]*table.Table, len(r.tables))
+			copy(tables, r.tables)
+			cd.midLevels = append(cd.midLevels, r)
+			cd.mid = append(cd.mid, tables)
+		}
+	}
+	cd.thisRange = infRange
+	cd.nextRange = infRange
+	cd.thisSize = cd.thisLevel.totalSize
+	return s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, *cd)
+}
+
 func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
 	timeStart := time.Now()
 
 	thisLevel := cd.thisLevel
 	nextLevel := cd.nextLevel
 
-	// Table should never be moved directly between levels, always be rewritten to allow discarding
-	// invalid versions.
+	info := CompactionInfo{
+		Level:     l,
+		NextLevel: nextLevel.level,
+		Inputs:    tableInfos(cd.top, thisLevel.level),
+	}
+	for i, lh := range cd.midLevels {
+		info.Inputs = append(info.Inputs, tableInfos(cd.mid[i], lh.level)...)
+	}
+	info.Inputs = append(info.Inputs, tableInfos(cd.bot, nextLevel.level)...)
+	s.kv.opt.EventListener.OnCompactionBegin(info)
+	defer func() {
+		info.Duration = time.Since(timeStart)
+		s.kv.opt.EventListener.OnCompactionEnd(info, err)
+	}()
+
+	// Tables are generally rewritten instead of being moved between levels, to allow discarding
+	// invalid versions. The exception is a table which doesn't overlap with the next level. See
+	// isTrivialMove.
+	if s.isTrivialMove(cd) {
+		info.TrivialMove = true
+		if err := s.moveTables(l, cd); err != nil {
+			return err
+		}
+		info.Outputs = tableInfos(cd.top, nextLevel.level)
+		return nil
+	}
 
 	newTables, decr, err := s.compactBuildTables(l, cd)
 	if err != nil {
@@ -641,18 +937,63 @@ func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
 
 	// See comment earlier in this function about the ordering of these ops, and the order in which
 	// we access levels when reading.
-	if err := nextLevel.replaceTables(newTables); err != nil {
+	if err := nextLevel.replaceTables(cd.bot, newTables); err != nil {
 		return err
 	}
+	for i, lh := range cd.midLevels {
+		if err := lh.deleteTables(cd.mid[i]); err != nil {
+			return err
+		}
+	}
 	if err := thisLevel.deleteTables(cd.top); err != nil {
 		return err
 	}
 
 	// Note: For level 0, while doCompact is running, it is possible that new tables are added.
 	// However, the tables are added only to the end, so it is ok to just delete the first table.
+	info.Outputs = tableInfos(newTables, nextLevel.level)
 
 	cd.elog.LazyPrintf("LOG Compact %d->%d, del %d tables, add %d tables, took %v\n",
-		l, l+1, len(cd.top)+len(cd.bot), len(newTables), time.Since(timeStart))
+		l, nextLevel.level, len(cd.allTables()), len(newTables), time.Since(timeStart))
+	return nil
+}
+
+// isTrivialMove returns true if the tables in cd can be moved to the next level as they are. That
+// is the case when there's a single table to compact, and no table in the next level overlaps with
+// it, so rewriting it would only copy every key over. We never move tables into the last level,
+// because that's the only place where deleted and expired keys can finally be dropped. Nor do we
+// move tables picked for their garbage, which is only dropped by rewriting them.
+func (s *levelsController) isTrivialMove(cd compactDef) bool {
+	return len(cd.top) == 1 && len(cd.bot) == 0 && len(cd.mid) == 0 && !cd.garbage &&
+		cd.nextLevel.level < s.kv.opt.MaxLevels-1
+}
+
+// moveTables moves the tables in cd.top to the next level, by only updating the manifest and the
+// level handlers. No data is read or written.
+func (s *levelsController) moveTables(l int, cd compactDef) error {
+	timeStart := time.Now()
+
+	changes := make([]*pb.ManifestChange, 0, 2*len(cd.top))
+	for _, t := range cd.top {
+		changes = append(changes, makeTableDeleteChange(t.ID()))
+		changes = append(changes, makeTableCreateChange(t.ID(), cd.nextLevel.level))
+	}
+	if err := s.kv.manifest.addChanges(changes); err != nil {
+		return err
+	}
+
+	// replaceTables acquires a reference on the tables, and deleteTables releases the one held by
+	// this level. So, the tables don't get deleted.
+	if err := cd.nextLevel.replaceTables(nil, cd.top); err != nil {
+		return err
+	}
+	if err := cd.thisLevel.deleteTables(cd.top); err != nil {
+		return err
+	}
+	y.NumTrivialMoves.Add(int64(len(cd.top)))
+
+	cd.elog.LazyPrintf("LOG Compact %d->%d, moved %d tables, took %v\n",
+		l, cd.nextLevel.level, len(cd.top), time.Since(timeStart))
 	return nil
 }
 
@@ -665,6 +1006,7 @@ func (s *levelsController) doCompact(p compactionPriority) error {
 		elog:      trace.New(fmt.Sprintf("Badger.L%d", l), "Compact"),
 		thisLevel: s.levels[l],
 		nextLevel: s.levels[l+1],
+		garbage:   p.garbage,
 	}
 	cd.elog.SetMaxEvents(100)
 	defer cd.elog.Finish()
@@ -673,7 +1015,18 @@ func (s *levelsController) doCompact(p compactionPriority) error {
 
 	// While picking tables to be compacted, both levels' tables are expected to
 	// remain unchanged.
-	if l == 0 {
+	if s.kv.opt.CompactionStyle == options.TieredCompaction {
+		// Tiered compactions always start from level 0, and pick the levels to merge with by
+		// themselves. Priorities for other levels only come from Flatten, which wants all the
+		// levels merged together.
+		full := l > 0
+		l = 0
+		cd.thisLevel = s.levels[0]
+		if !s.fillTablesTiered(&cd, full) {
+			cd.elog.LazyPrintf("fillTablesTiered failed\n")
+			return fmt.Errorf("Unable to fill tables for tiered compaction\n")
+		}
+	} else if l == 0 {
 		if !s.fillTablesL0(&cd) {
 			cd.elog.LazyPrintf("fillTables failed for level: %d\n", l)
 			return fmt.Errorf("Unable to fill tables for level: %d\n", l)
@@ -715,6 +1068,8 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 	for !s.levels[0].tryAddLevel0Table(t) {
 		// Stall. Make sure all levels are healthy before we unstall.
 		var timeStart time.Time
+		stall := StallInfo{NumLevelZeroTables: s.levels[0].numTables()}
+		s.kv.opt.EventListener.OnStallBegin(stall)
 		{
 			s.elog.Printf("STALLED STALLED STALLED: %v\n", time.Since(lastUnstalled))
 			s.cstatus.RLock()
@@ -733,7 +1088,9 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 			// not having finished -- we wait for them to finish.  Also, it's crucial this behavior
 			// replicates pickCompactLevels' behavior in computing compactability in order to
 			// guarantee progress.
-			if !s.isLevel0Compactable() && !s.levels[1].isCompactable(0) {
+			// Tiered compactions don't look at the size of level 1, so neither do we.
+			tiered := s.kv.opt.CompactionStyle == options.TieredCompaction
+			if !s.isLevel0Compactable() && (tiered || !s.levels[1].isCompactable(0)) {
 				break
 			}
 			time.Sleep(10 * time.Millisecond)
@@ -747,6 +1104,8 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 			s.elog.Printf("UNSTALLED UNSTALLED UNSTALLED: %v\n", time.Since(timeStart))
 			lastUnstalled = time.Now()
 		}
+		stall.Duration = time.Since(timeStart)
+		s.kv.opt.EventListener.OnStallEnd(stall)
 	}
 
 	return nil
@@ -786,6 +1145,18 @@ func (s *levelsController) get(key []byte, maxVs *y.ValueStruct) (y.ValueStruct,
 	return y.ValueStruct{}, nil
 }
 
+// getMany is like get, for a batch of keys sorted in ascending order. It looks up the keys which
+// aren't done yet, walking the levels once for the whole batch, and marks the ones found as done.
+func (s *levelsController) getMany(keys [][]byte, vs []y.ValueStruct, done []bool) error {
+	// Iterate the levels from 0 on upward, for the same reason as in get.
+	for _, h := range s.levels {
+		if err := h.getMany(keys, vs, done); err != nil {
+			return errors.Wrapf(err, "get %d keys", len(keys))
+		}
+	}
+	return nil
+}
+
 func appendIteratorsReversed(out []y.Iterator, th []*table.Table, reversed bool) []y.Iterator {
 	for i := len(th) - 1; i >= 0; i-- {
 		// This will increment the reference of the table handler.
@@ -797,11 +1168,11 @@ func appendIteratorsReversed(out []y.Iterator, th []*table.Table, reversed bool)
 // appendIterators appends iterators to an array of iterators, for merging.
 // Note: This obtains references for the table handlers. Remember to close these iterators.
 func (s *levelsController) appendIterators(
-	iters []y.Iterator, opt *IteratorOptions) []y.Iterator {
+	iters []y.Iterator, opt *IteratorOptions, stats *IteratorStats) []y.Iterator {
 	// Just like with get, it's important we iterate the levels from 0 on upward, to avoid missing
 	// data when there's a compaction.
 	for _, level := range s.levels {
-		iters = level.appendIterators(iters, opt)
+		iters = level.appendIterators(iters, opt, stats)
 	}
 	return iters
 }
@@ -811,19 +1182,31 @@ type TableInfo struct {
 	Level int
 	Left  []byte
 	Right []byte
+	Size  int64
+}
+
+// estimateRange adds up the estimates of all the tables for the keys in [start, end). See
+// table.Table.EstimateRange.
+func (s *levelsController) estimateRange(start, end []byte) (size int64, count uint64) {
+	for _, l := range s.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			sz, n := t.EstimateRange(start, end)
+			size += sz
+			count += n
+		}
+		l.RUnlock()
+	}
+	return size, count
 }
 
 func (s *levelsController) getTableInfo() (result []TableInfo) {
 	for _, l := range s.levels {
+		l.RLock()
 		for _, t := range l.tables {
-			info := TableInfo{
-				ID:    t.ID(),
-				Level: l.level,
-				Left:  t.Smallest(),
-				Right: t.Biggest(),
-			}
-			result = append(result, info)
+			result = append(result, newTableInfo(t, l.level))
 		}
+		l.RUnlock()
 	}
 	sort.Slice(result, func(i, j int) bool {
 		if result[i].Level != result[j].Level {
@@ -0,0 +1,389 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/options"
+	"github.com/dgraph-io/badger/y"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubcompactions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := getTestOptions(dir)
+	opts.NumSubcompactions = 4
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%08d", i))
+	}
+	n := 5000
+	for round := 0; round < 2; round++ {
+		txn := db.NewTransaction(true)
+		for i := 0; i < n; i++ {
+			val := []byte(fmt.Sprintf("%d-%d", round, i))
+			if err := txn.Set(key(i), val); err == ErrTxnTooBig {
+				require.NoError(t, txn.Commit())
+				txn = db.NewTransaction(true)
+				require.NoError(t, txn.Set(key(i), val))
+			} else {
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, txn.Commit())
+	}
+
+	// Check that the split points produce disjoint, ordered ranges covering the whole keyspace.
+	cd := compactDef{
+		thisLevel: db.lc.levels[0],
+		nextLevel: db.lc.levels[1],
+	}
+	cd.lockLevels()
+	cd.top = append(cd.top, cd.thisLevel.tables...)
+	cd.bot = append(cd.bot, cd.nextLevel.tables...)
+	cd.unlockLevels()
+	ranges := db.lc.subcompactionRanges(0, cd)
+	require.True(t, len(ranges) > 1)
+	require.True(t, len(ranges) <= opts.NumSubcompactions)
+	require.Nil(t, ranges[0].left)
+	require.Nil(t, ranges[len(ranges)-1].right)
+	for i := 1; i < len(ranges); i++ {
+		require.Equal(t, ranges[i-1].right, ranges[i].left)
+		require.True(t, bytes.Compare(ranges[i].left, ranges[i-1].left) > 0)
+	}
+
+	// Closing the DB would compact all of level 0 into level 1.
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, 0, db.lc.levels[0].numTables())
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < n; i++ {
+			item, err := txn.Get(key(i))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("1-%d", i)), getItemValue(t, item))
+		}
+		return nil
+	}))
+}
+
+func TestTrivialMove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := getTestOptions(dir)
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%08d", i))
+	}
+	n := 100
+	for i := 0; i < n; i++ {
+		txnSet(t, db, key(i), []byte(fmt.Sprintf("%d", i)), 0x00)
+	}
+
+	// Closing the DB flushes the memtable into a single L0 table, and then compacts level 0. As
+	// level 1 is empty, the table should be moved without being rewritten.
+	moves := y.NumTrivialMoves.Value()
+	require.NoError(t, db.Close())
+	require.Equal(t, moves+1, y.NumTrivialMoves.Value())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, 0, db.lc.levels[0].numTables())
+	require.Equal(t, 1, db.lc.levels[1].numTables())
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < n; i++ {
+			item, err := txn.Get(key(i))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("%d", i)), getItemValue(t, item))
+		}
+		return nil
+	}))
+}
+
+func TestCompactionRateAutoTune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opt := getTestOptions(dir)
+	opt.CompactionRateLimit = 4 << 20
+	opt.AutoTuneCompactionRate = true
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Level 0 is empty, so compactions should only get a quarter of the limit. Flushes get all of it.
+	require.Equal(t, int64(1<<20), db.compactionRateLimiter.Rate())
+	require.Equal(t, int64(4<<20), db.rateLimiter.Rate())
+
+	db.SetCompactionRateLimit(8 << 20)
+	require.Equal(t, int64(2<<20), db.compactionRateLimiter.Rate())
+	require.Equal(t, int64(8<<20), db.rateLimiter.Rate())
+
+	db.SetCompactionRateLimit(0)
+	require.Equal(t, int64(0), db.compactionRateLimiter.Rate())
+	require.Equal(t, int64(0), db.rateLimiter.Rate())
+}
+
+func TestPauseCompactions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opt := getTestOptions(dir)
+	opt.NumLevelZeroTables = 2
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.PauseCompactions(context.Background()))
+	// Pausing again is a no-op.
+	require.NoError(t, db.PauseCompactions(context.Background()))
+
+	for i := 0; i < 2000; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("%08d", i)), []byte(fmt.Sprintf("val%d", i)), 0)
+	}
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return false
+	}
+	require.True(t, waitFor(func() bool {
+		return db.lc.levels[0].numTables() >= opt.NumLevelZeroTables
+	}))
+	// Give the compactors a chance to run, if they were still around.
+	time.Sleep(2 * time.Second)
+	require.Equal(t, 0, db.lc.levels[1].numTables())
+
+	db.ResumeCompactions()
+	require.True(t, waitFor(func() bool { return db.lc.levels[1].numTables() > 0 }))
+}
+
+func TestGarbageCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opt := getTestOptions(dir)
+	opt.CompactionGarbageRatio = 0.5
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%08d", i))
+	}
+	// Write enough keys to fill up multiple levels, then delete most of them.
+	n := 5000
+	for i := 0; i < n; i++ {
+		txnSet(t, db, key(i), []byte(fmt.Sprintf("val%d", i)), 0)
+	}
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		if i%10 != 0 {
+			txnDelete(t, db, key(i))
+		}
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	// The deleted versions can be discarded once no read could see them.
+	require.NoError(t, db.View(func(txn *Txn) error { return nil }))
+
+	garbageTables := func() int {
+		var count int
+		for _, l := range db.lc.levels[1 : len(db.lc.levels)-1] {
+			l.RLock()
+			for _, t := range l.tables {
+				if t.Stats().GarbageRatio(uint64(time.Now().Unix())) > opt.CompactionGarbageRatio {
+					count++
+				}
+			}
+			l.RUnlock()
+		}
+		return count
+	}
+	require.True(t, garbageTables() > 0)
+	for i := 0; i < 100 && garbageTables() > 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Zero(t, garbageTables())
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < n; i++ {
+			item, err := txn.Get(key(i))
+			if i%10 != 0 {
+				require.Equal(t, ErrKeyNotFound, err)
+				continue
+			}
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("val%d", i)), getItemValue(t, item))
+		}
+		return nil
+	}))
+}
+
+func TestTieredCompaction(t *testing.T) {
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%08d", i))
+	}
+	// Overwrites a small key space many times over, and checks the layout of the levels.
+	run := func(style options.CompactionStyle) {
+		dir, err := ioutil.TempDir("", "badger")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		opt := getTestOptions(dir)
+		opt.CompactionStyle = style
+		db, err := Open(opt)
+		require.NoError(t, err)
+
+		rng := rand.New(rand.NewSource(1))
+		n := 3000
+		final := make(map[int]int)
+		for round := 0; round < 1000; round++ {
+			txn := db.NewTransaction(true)
+			for j := 0; j < 20; j++ {
+				i := rng.Intn(n)
+				final[i] = round
+				require.NoError(t, txn.Set(key(i), []byte(fmt.Sprintf("%d-%d", round, i))))
+			}
+			require.NoError(t, txn.Commit())
+		}
+		require.NoError(t, db.Close())
+
+		db, err = Open(opt)
+		require.NoError(t, err)
+		defer db.Close()
+		if style == options.TieredCompaction {
+			// Every level holds a single sorted run, so tables within a level can't overlap. The
+			// runs in the upper levels are newer than those below, though the writes of a txn can
+			// end up in two runs. That doesn't go for the internal keys, like the value log head,
+			// which is stored with the latest timestamp on flushes.
+			require.NoError(t, db.PauseCompactions(context.Background()))
+			var lastMin uint64 = math.MaxUint64
+			for _, lh := range db.lc.levels[1:] {
+				require.NoError(t, lh.validate())
+				if lh.numTables() == 0 {
+					continue
+				}
+				min, max := uint64(math.MaxUint64), uint64(0)
+				for _, tbl := range lh.tables {
+					it := tbl.NewIterator(false)
+					for it.Rewind(); it.Valid(); it.Next() {
+						if bytes.HasPrefix(it.Key(), badgerPrefix) {
+							continue
+						}
+						version := y.ParseTs(it.Key())
+						if version < min {
+							min = version
+						}
+						if version > max {
+							max = version
+						}
+					}
+					require.NoError(t, it.Close())
+				}
+				require.True(t, max <= lastMin, "Level %d is newer than the levels above.", lh.level)
+				lastMin = min
+			}
+			db.ResumeCompactions()
+			// Flatten merges all the runs into one.
+			require.NoError(t, db.Flatten(1))
+			var nonEmpty int
+			for _, lh := range db.lc.levels {
+				if lh.numTables() > 0 {
+					nonEmpty++
+				}
+			}
+			require.Equal(t, 1, nonEmpty)
+		}
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < n; i++ {
+				item, err := txn.Get(key(i))
+				round, ok := final[i]
+				if !ok {
+					require.Equal(t, ErrKeyNotFound, err)
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("%d-%d", round, i), string(getItemValue(t, item)))
+			}
+			return nil
+		}))
+	}
+
+	run(options.LeveledCompaction)
+	run(options.TieredCompaction)
+}
+
+func TestTablesDuringCompactions(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		errCh := make(chan error, 1)
+		go func() {
+			batch := db.NewWriteBatch()
+			for i := 0; i < 20000; i++ {
+				if err := batch.Set([]byte(fmt.Sprintf("%08d", i)), []byte("val"), 0); err != nil {
+					batch.Cancel()
+					errCh <- err
+					return
+				}
+			}
+			errCh <- batch.Flush()
+		}()
+		// The levels change under flushes and compactions while their tables are listed.
+		for {
+			select {
+			case err := <-errCh:
+				require.NoError(t, err)
+				require.NotEmpty(t, db.Tables())
+				return
+			default:
+				db.Tables()
+			}
+		}
+	})
+}
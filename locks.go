@@ -0,0 +1,113 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync"
+	"time"
+)
+
+// lockManager keeps track of the key locks taken by transactions via Txn.Lock. The locks live in
+// memory only, and are released once the owning txn is committed or discarded.
+type lockManager struct {
+	sync.Mutex
+	locks map[string]*keyLock
+	// waitsFor has an edge from each txn waiting for a lock, to the txn holding it. A cycle in
+	// this graph is a deadlock.
+	waitsFor map[*Txn]*Txn
+}
+
+type keyLock struct {
+	owner    *Txn
+	released chan struct{} // Closed once the owner releases the lock.
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks:    make(map[string]*keyLock),
+		waitsFor: make(map[*Txn]*Txn),
+	}
+}
+
+// lock acquires the lock on key for txn, waiting for at most timeout if it's held by another txn.
+// A zero timeout waits until the lock is released.
+func (lm *lockManager) lock(txn *Txn, key string, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		lm.Lock()
+		kl, ok := lm.locks[key]
+		if !ok {
+			lm.locks[key] = &keyLock{owner: txn, released: make(chan struct{})}
+			delete(lm.waitsFor, txn)
+			lm.Unlock()
+			return nil
+		}
+		if lm.deadlocks(txn, kl.owner) {
+			delete(lm.waitsFor, txn)
+			lm.Unlock()
+			return ErrDeadlock
+		}
+		lm.waitsFor[txn] = kl.owner
+		lm.Unlock()
+
+		select {
+		case <-kl.released:
+			// The lock might be taken by someone else by now. Try again.
+		case <-deadline:
+			lm.Lock()
+			delete(lm.waitsFor, txn)
+			lm.Unlock()
+			return ErrLockTimeout
+		}
+	}
+}
+
+// deadlocks returns true if txn waiting for owner would close a cycle in the wait-for graph. It
+// must be called while holding the lock.
+func (lm *lockManager) deadlocks(txn, owner *Txn) bool {
+	for t := owner; t != nil; t = lm.waitsFor[t] {
+		if t == txn {
+			return true
+		}
+	}
+	return false
+}
+
+// unlock releases the locks held by txn on the given keys, waking up the txns waiting for them.
+func (lm *lockManager) unlock(txn *Txn, keys map[string]struct{}) {
+	lm.Lock()
+	defer lm.Unlock()
+
+	for key := range keys {
+		if kl, ok := lm.locks[key]; ok && kl.owner == txn {
+			delete(lm.locks, key)
+			close(kl.released)
+		}
+	}
+	// Waiters are no longer waiting for txn, even if they haven't woken up yet.
+	for t, owner := range lm.waitsFor {
+		if owner == txn {
+			delete(lm.waitsFor, t)
+		}
+	}
+}
@@ -143,7 +143,7 @@ func buildTable(t *testing.T, keyValues [][]string) *os.File {
 			Value:    []byte(kv[1]),
 			Meta:     'A',
 			UserMeta: 0,
-		})
+		}, false)
 		if t != nil {
 			require.NoError(t, err)
 		} else {
@@ -17,6 +17,8 @@
 package badger
 
 import (
+	"time"
+
 	"github.com/dgraph-io/badger/options"
 )
 
@@ -84,6 +86,77 @@ type Options struct {
 	// to happen within LSM tree. If set to zero, writes could block forever.
 	NumCompactors int
 
+	// Maximum number of goroutines a single L0->L1 compaction is split into. The key range being
+	// compacted is divided into disjoint sub-ranges, each of which is merged and written out in
+	// parallel. Setting this to one disables subcompactions.
+	NumSubcompactions int
+
+	// Maximum number of bytes per second written to disk by background work: compactions,
+	// memtable flushes and value log GC rewrites. Zero means no limit. The limit can be changed
+	// at runtime via DB.SetCompactionRateLimit.
+	CompactionRateLimit int64
+
+	// If set, the rate limit of compactions and value log GC is tuned between a quarter of
+	// CompactionRateLimit and the full limit, depending on how close level 0 is to stalling writes.
+	// Memtable flushes are only held to the full limit.
+	AutoTuneCompactionRate bool
+
+	// How tables get compacted. See options.CompactionStyle.
+	CompactionStyle options.CompactionStyle
+
+	// Only used by tiered compaction. A sorted run is merged with the newer runs before it, if it
+	// is at most this many percent bigger than all of them combined.
+	TieredSizeRatio int
+
+	// Only used by tiered compaction. All sorted runs are merged together once the newer runs
+	// take up more than this many percent of the size of the oldest run.
+	TieredMaxSizeAmplification int
+
+	// Tables with a higher fraction of delete markers and expired keys than this get compacted,
+	// even if their level is within its size target. Zero disables this.
+	CompactionGarbageRatio float64
+
+	// Keep the keys read by update transactions, besides their fingerprints. With this set, a
+	// conflicting commit returns a *ConflictError naming the contended keys, instead of
+	// ErrConflict. Costs memory per key read.
+	KeepConflictKeys bool
+
+	// Detect conflicts the way serializable snapshot isolation does. By default, a transaction
+	// fails to commit whenever a concurrent transaction which committed first wrote any key it
+	// read. With this set, a transaction only fails if it would complete a dangerous structure
+	// of read-write dependencies between concurrent transactions. Transactions stay serializable,
+	// while fewer of them fail, at the cost of tracking them in more detail. Read-only
+	// transactions aren't tracked, so they might see commits in an order which differs from the
+	// order the transactions are serialized in.
+	SerializableSnapshotIsolation bool
+
+	// Allow transactions with more writes than fit into a single write batch. Instead of returning
+	// ErrTxnTooBig, such a txn spills its pending writes to a temporary file next to the value
+	// log. On commit, they're streamed from the file in as many write batches as it takes, and
+	// still applied atomically. The writes of a txn need to fit into a single value log file.
+	SpillLargeTxns bool
+
+	// Maximum size of the spilled writes of a txn, counting keys and the values stored next to
+	// them in the LSM tree, or the pointers to values stored in the value log. Committing a spilled
+	// txn holds all of this in memory until the last of its writes is in the value log, so that
+	// the txn is applied to the LSM tree as a whole. A txn growing past it returns ErrTxnTooBig.
+	MaxSpilledTxnSize int64
+
+	// Maximum time Txn.Lock and Txn.GetForUpdate wait for a key lock held by another transaction,
+	// before returning ErrLockTimeout. Zero means waiting until the lock is released. Deadlocks
+	// are detected regardless.
+	LockTimeout time.Duration
+
+	// Keep all the versions written within this window before now, regardless of
+	// NumVersionsToKeep, so they can be read via DB.NewTransactionAtVersion. Zero disables it. The
+	// commit times are only tracked while the DB is open, so after reopening it, versions are kept
+	// for a full window since the first commit, and only the versions committed since then, or the
+	// latest one before, can be read at.
+	VersionRetention time.Duration
+
+	// Gets notified about memtable flushes, compactions, write stalls and value log GC.
+	EventListener EventListener
+
 	// When closing the DB, force compact Level 0. This ensures that both reads and writes are
 	// efficient when the DB is opened later.
 	CompactL0OnClose bool
@@ -120,6 +193,8 @@ var DefaultOptions = Options{
 	MaxLevels:               7,
 	MaxTableSize:            64 << 20,
 	NumCompactors:           2, // Compactions can be expensive. Only run 2.
+	NumSubcompactions:       4,
+	CompactionGarbageRatio:  0.5,
 	NumLevelZeroTables:      5,
 	NumLevelZeroTablesStall: 10,
 	NumMemtables:            5,
@@ -135,6 +210,11 @@ var DefaultOptions = Options{
 	ValueLogMaxEntries: 1000000,
 	ValueThreshold:     32,
 	Truncate:           false,
+
+	MaxSpilledTxnSize: 256 << 20,
+
+	TieredSizeRatio:            1,
+	TieredMaxSizeAmplification: 200,
 }
 
 // LSMOnlyOptions follows from DefaultOptions, but sets a higher ValueThreshold
@@ -28,3 +28,17 @@ const (
 	// MemoryMap indicates that that the file must be memory-mapped
 	MemoryMap
 )
+
+// CompactionStyle specifies how tables in the LSM tree get compacted.
+type CompactionStyle int
+
+const (
+	// LeveledCompaction merges tables from each level into the level below it, once the level
+	// outgrows its size target. This keeps read and space amplification low, at the cost of
+	// rewriting data once for every level.
+	LeveledCompaction CompactionStyle = iota
+	// TieredCompaction keeps every level as a single sorted run, and merges runs of similar size
+	// together. This rewrites data less often, so it suits write heavy workloads, at the cost of
+	// more space and slower reads.
+	TieredCompaction
+)
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/y"
+	"github.com/pkg/errors"
+)
+
+// commitTime records that the commit at ts happened at the given time. All the commits at or
+// below ts happened by then.
+type commitTime struct {
+	ts uint64
+	at time.Time
+}
+
+// recordCommitTime samples the time of the commit at ts for Options.VersionRetention, at most ten
+// times per retention window. It must be called while having a lock.
+func (o *oracle) recordCommitTime(ts uint64) {
+	if o.retention <= 0 {
+		return
+	}
+	now := time.Now()
+	if n := len(o.commitTimes); n > 0 && now.Sub(o.commitTimes[n-1].at) < o.retention/10 {
+		return
+	}
+	o.commitTimes = append(o.commitTimes, commitTime{ts: ts, at: now})
+}
+
+// retainedAbove returns a timestamp, such that all the versions above it might have been written
+// within the retention window. It must be called while having a lock.
+func (o *oracle) retainedAbove() uint64 {
+	cutoff := time.Now().Add(-o.retention)
+	idx := sort.Search(len(o.commitTimes), func(i int) bool {
+		return o.commitTimes[i].at.After(cutoff)
+	})
+	if idx == 0 {
+		return 0
+	}
+	// The earlier samples won't be needed anymore.
+	o.commitTimes = o.commitTimes[idx-1:]
+	return o.commitTimes[0].ts
+}
+
+// pinRead keeps the versions visible at readTs from being discarded, until unpinRead is called.
+func (o *oracle) pinRead(readTs uint64) error {
+	o.Lock()
+	if last := o.nextTxnTs - 1; readTs > last {
+		o.Unlock()
+		return errors.Errorf("Cannot read at version %d, beyond the latest commit: %d", readTs, last)
+	}
+	if readTs < o.discardedTs {
+		o.Unlock()
+		return ErrVersionDiscarded
+	}
+	o.pastReads[readTs]++
+	o.Unlock()
+
+	// Make sure all the commits up to readTs are visible.
+	y.Check(o.txnMark.WaitForMark(context.Background(), readTs))
+	return nil
+}
+
+func (o *oracle) unpinRead(readTs uint64) {
+	o.Lock()
+	defer o.Unlock()
+	if o.pastReads[readTs]--; o.pastReads[readTs] <= 0 {
+		delete(o.pastReads, readTs)
+	}
+}
+
+// NewTransactionAtVersion returns a read-only transaction, which reads the DB as it was at readTs,
+// a commit timestamp in the past as returned by Item.Version. It's the counterpart of
+// NewTransactionAt for DBs which aren't managed.
+//
+// Older versions are only kept around if NumVersionsToKeep allows for it, or if they're within
+// Options.VersionRetention. If compactions might have discarded versions visible at readTs already,
+// ErrVersionDiscarded is returned. That's assumed of every version before the latest commit when
+// the DB was opened. Otherwise, those versions are kept until the transaction is discarded.
+func (db *DB) NewTransactionAtVersion(readTs uint64) (*Txn, error) {
+	if db.opt.managedTxns {
+		panic("Cannot use NewTransactionAtVersion with managedDB=true. Use NewTransactionAt instead.")
+	}
+	if err := db.orc.pinRead(readTs); err != nil {
+		return nil, err
+	}
+	txn := db.newTransaction(false, true)
+	txn.readTs = readTs
+	txn.atVersion = true
+	return txn, nil
+}
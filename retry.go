@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/dgraph-io/badger/y"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy determines how DB.UpdateWithRetry retries transactions which conflict.
+type RetryPolicy struct {
+	// Maximum number of times the transaction is run. Zero means retrying until it succeeds.
+	MaxAttempts int
+
+	// Maximum time to wait before the first retry. The wait doubles with every retry, up to
+	// MaxBackoff. The actual wait is picked at random, up to this maximum, so that conflicting
+	// transactions don't keep running in lockstep. A zero MaxBackoff stands for the one of
+	// DefaultRetryPolicy, or InitialBackoff if that's longer.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// If set, gets called after every conflict, with the number of conflicts so far, and the
+	// error returned by the commit.
+	OnConflict func(conflicts int, err error)
+}
+
+// DefaultRetryPolicy is a RetryPolicy which should work for most applications.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     100 * time.Millisecond,
+}
+
+// UpdateWithRetry acts like Update, but runs fn in a new transaction again whenever committing
+// fails with ErrConflict, as set out by policy. Errors returned by fn aren't retried. Once the
+// attempts run out, the last conflict error is returned.
+func (db *DB) UpdateWithRetry(fn func(txn *Txn) error, policy RetryPolicy) error {
+	backoff := policy.InitialBackoff
+	for conflicts := 0; ; {
+		err := db.Update(fn)
+		if !isConflict(err) {
+			return err
+		}
+		conflicts++
+		if policy.OnConflict != nil {
+			policy.OnConflict(conflicts, err)
+		}
+		if policy.MaxAttempts > 0 && conflicts >= policy.MaxAttempts {
+			y.NumTxnRetriesExhausted.Add(1)
+			return err
+		}
+
+		y.NumTxnRetries.Add(1)
+		if backoff > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(backoff))))
+			backoff = policy.nextBackoff(backoff)
+		}
+	}
+}
+
+// nextBackoff doubles the backoff, up to the maximum.
+func (p RetryPolicy) nextBackoff(backoff time.Duration) time.Duration {
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = DefaultRetryPolicy.MaxBackoff
+		if p.InitialBackoff > max {
+			max = p.InitialBackoff
+		}
+	}
+	if backoff >= max/2 {
+		return max
+	}
+	return 2 * backoff
+}
+
+func isConflict(err error) bool {
+	err = errors.Cause(err)
+	if _, ok := err.(*ConflictError); ok {
+		return true
+	}
+	return err == ErrConflict
+}
@@ -512,5 +512,8 @@ func (s *UniIterator) Value() y.ValueStruct { return s.iter.Value() }
 // Valid implements y.Interface
 func (s *UniIterator) Valid() bool { return s.iter.Valid() }
 
+// SetReversed implements y.Interface
+func (s *UniIterator) SetReversed(reversed bool) { s.reversed = reversed }
+
 // Close implements y.Interface (and frees up the iter's resources)
 func (s *UniIterator) Close() error { return s.iter.Close() }
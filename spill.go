@@ -0,0 +1,300 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dgraph-io/badger/y"
+	"github.com/pkg/errors"
+)
+
+const spillFileSuffix = ".spill"
+
+// Bytes read ahead of the entry asked for, when reading a run of spilled entries.
+const spillReadAhead = 64 << 10
+
+// txnSpill holds the pending writes of a txn which outgrew a single write batch, with
+// Options.SpillLargeTxns set. The entries are appended to a temporary file in the value log
+// format, in a run sorted by key per spill, and only their location is kept in memory.
+type txnSpill struct {
+	fd   *os.File
+	size int64   // Bytes written to fd.
+	runs []int64 // Offsets at which the runs start.
+
+	// lsmSize is the size of the spilled writes as they go into the LSM tree, which is what's held
+	// in memory while the txn is written. See DB.holdTxnPart. Keys written again count again.
+	lsmSize int64
+
+	// ptrs points to the latest spilled entry per key.
+	ptrs map[string]spillPtr
+}
+
+type spillPtr struct {
+	offset int64
+	len    uint32
+}
+
+// spillRef is a spilled entry, as read back by spillIterator and Txn.sendSpilled.
+type spillRef struct {
+	key []byte
+	ptr spillPtr
+}
+
+// newTxnSpill creates the spill file with the given id, which has to be unique among the txns
+// running.
+func newTxnSpill(dir string, id uint64) (*txnSpill, error) {
+	path := filepath.Join(dir, fmt.Sprintf("txn-%d%s", id, spillFileSuffix))
+	fd, err := y.CreateSyncedFile(path, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create spill file")
+	}
+	return &txnSpill{fd: fd, ptrs: make(map[string]spillPtr)}, nil
+}
+
+// write appends the entries to the spill file, as a new run sorted by key.
+func (s *txnSpill) write(entries map[string]*Entry) error {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	ptrs := make([]spillPtr, len(keys))
+	offset := s.size
+	for i, k := range keys {
+		n, err := encodeEntry(entries[k], &buf)
+		if err != nil {
+			return err
+		}
+		ptrs[i] = spillPtr{offset: offset, len: uint32(n)}
+		offset += int64(n)
+	}
+	if _, err := s.fd.Write(buf.Bytes()); err != nil {
+		return errors.Wrapf(err, "Unable to write to spill file: %q", s.fd.Name())
+	}
+	for i, k := range keys {
+		s.ptrs[k] = ptrs[i]
+	}
+	s.runs = append(s.runs, s.size)
+	s.size = offset
+	return nil
+}
+
+// get returns the spilled entry for key, or nil if there's none.
+func (s *txnSpill) get(key []byte) (*Entry, error) {
+	p, ok := s.ptrs[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	buf := make([]byte, p.len)
+	if _, err := s.fd.ReadAt(buf, p.offset); err != nil {
+		return nil, errors.Wrapf(err, "Unable to read from spill file: %q", s.fd.Name())
+	}
+	e := valueBytesToEntry(buf)
+	return &e, nil
+}
+
+// sortedRuns returns the latest spilled entries, apart from the ones for keys in skip, grouped by
+// run. The entries of a run are sorted by key, which is also the order they're laid out in the
+// file, so a run can be read sequentially. Runs left without entries are dropped.
+func (s *txnSpill) sortedRuns(skip map[string]*Entry) [][]spillRef {
+	runs := make([][]spillRef, len(s.runs))
+	for k, p := range s.ptrs {
+		if _, has := skip[k]; has {
+			continue
+		}
+		i := sort.Search(len(s.runs), func(i int) bool { return s.runs[i] > p.offset }) - 1
+		runs[i] = append(runs[i], spillRef{key: []byte(k), ptr: p})
+	}
+	res := runs[:0]
+	for _, run := range runs {
+		if len(run) == 0 {
+			continue
+		}
+		sort.Slice(run, func(i, j int) bool { return run[i].ptr.offset < run[j].ptr.offset })
+		res = append(res, run)
+	}
+	return res
+}
+
+// spillReader reads the entries of a run, reading ahead of them in the direction they're read in.
+type spillReader struct {
+	spill  *txnSpill
+	buf    []byte
+	offset int64 // Offset of buf in the file.
+}
+
+// read returns the entry at p. The entry refers to the read ahead buffer, which is never reused.
+func (r *spillReader) read(p spillPtr, reversed bool) (Entry, error) {
+	end := p.offset + int64(p.len)
+	if p.offset < r.offset || end > r.offset+int64(len(r.buf)) {
+		n := int64(spillReadAhead)
+		if n < int64(p.len) {
+			n = int64(p.len)
+		}
+		start := p.offset
+		if reversed {
+			if start = end - n; start < 0 {
+				start = 0
+			}
+		}
+		buf := make([]byte, n)
+		read, err := r.spill.fd.ReadAt(buf, start)
+		if int64(read) < end-start {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Entry{}, errors.Wrapf(err, "Unable to read from spill file: %q", r.spill.fd.Name())
+		}
+		r.buf, r.offset = buf[:read], start
+	}
+	return valueBytesToEntry(r.buf[p.offset-r.offset : end-r.offset]), nil
+}
+
+// spillIterator iterates over a run of spilled writes, like pendingWritesIterator does over the
+// writes held in memory. The values are read from the spill file as they're asked for. If that
+// fails, the error is kept in err, and the iterator returns an empty value.
+type spillIterator struct {
+	refs     []spillRef // Sorted in the order of iteration.
+	reader   spillReader
+	readTs   uint64
+	nextIdx  int
+	reversed bool
+
+	valIdx int // Index of the entry held in val, or -1.
+	val    y.ValueStruct
+	err    error
+}
+
+func (txn *Txn) newSpillIterators(reversed bool) []*spillIterator {
+	if !txn.update || txn.spill == nil {
+		return nil
+	}
+	var itrs []*spillIterator
+	for _, run := range txn.spill.sortedRuns(txn.pendingWrites) {
+		itr := &spillIterator{
+			refs:   run,
+			reader: spillReader{spill: txn.spill},
+			readTs: txn.readTs,
+			valIdx: -1,
+		}
+		itr.SetReversed(reversed)
+		itrs = append(itrs, itr)
+	}
+	return itrs
+}
+
+func (si *spillIterator) Next() {
+	si.nextIdx++
+}
+
+func (si *spillIterator) Rewind() {
+	si.nextIdx = 0
+}
+
+func (si *spillIterator) Seek(key []byte) {
+	key, version := y.ParseKey(key), y.ParseTs(key)
+	si.nextIdx = sort.Search(len(si.refs), func(idx int) bool {
+		cmp := bytes.Compare(si.refs[idx].key, key)
+		if cmp == 0 && si.readTs != version {
+			// All the entries are at readTs. Versions are sorted in descending order.
+			cmp = 1
+			if si.readTs > version {
+				cmp = -1
+			}
+		}
+		if !si.reversed {
+			return cmp >= 0
+		}
+		return cmp <= 0
+	})
+}
+
+func (si *spillIterator) Key() []byte {
+	y.AssertTrue(si.Valid())
+	return y.KeyWithTs(si.refs[si.nextIdx].key, si.readTs)
+}
+
+func (si *spillIterator) Value() y.ValueStruct {
+	y.AssertTrue(si.Valid())
+	if si.valIdx == si.nextIdx {
+		return si.val
+	}
+	si.valIdx = si.nextIdx
+	si.val = y.ValueStruct{Version: si.readTs}
+	e, err := si.reader.read(si.refs[si.nextIdx].ptr, si.reversed)
+	if err != nil {
+		if si.err == nil {
+			si.err = err
+		}
+		return si.val
+	}
+	si.val.Value = e.Value
+	si.val.Meta = e.meta
+	si.val.UserMeta = e.UserMeta
+	si.val.ExpiresAt = e.ExpiresAt
+	return si.val
+}
+
+func (si *spillIterator) Valid() bool {
+	return si.nextIdx < len(si.refs)
+}
+
+func (si *spillIterator) SetReversed(reversed bool) {
+	if si.reversed == reversed {
+		return
+	}
+	for i, j := 0, len(si.refs)-1; i < j; i, j = i+1, j-1 {
+		si.refs[i], si.refs[j] = si.refs[j], si.refs[i]
+	}
+	si.reversed = reversed
+	si.nextIdx = len(si.refs)
+	si.valIdx = -1
+}
+
+func (si *spillIterator) Close() error {
+	return nil
+}
+
+func (s *txnSpill) delete() error {
+	if err := s.fd.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.fd.Name())
+}
+
+// removeSpillFiles removes the spill files left behind by txns which were running when the
+// process stopped.
+func removeSpillFiles(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+spillFileSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return errors.Wrapf(err, "Unable to remove spill file: %q", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	farm "github.com/dgryski/go-farm"
+)
+
+// ssiTxn tracks an update txn for serializable snapshot isolation, see
+// Options.SerializableSnapshotIsolation. There's an rw-antidependency from txn R to txn W, if R
+// read a key which W wrote, without seeing that write, because the two ran concurrently. R then
+// has to be serialized before W. A cycle of such dependencies can't be serialized, and always
+// includes a pivot txn, with a dependency both into and out of it. So, no txn is allowed to
+// become a pivot.
+type ssiTxn struct {
+	sync.Mutex // Guards reads and readRanges, which the running txn appends to.
+	reads      []uint64
+	readRanges []readRange
+
+	// The following are set on commit, under the oracle lock.
+	commitTs uint64
+	writes   map[uint64]struct{}
+	keys     [][]byte // Sorted.
+
+	// Whether there's an rw-antidependency into, and out of, the txn. Guarded by the oracle lock.
+	in, out bool
+}
+
+func (st *ssiTxn) addRead(fp uint64) {
+	st.Lock()
+	st.reads = append(st.reads, fp)
+	st.Unlock()
+}
+
+func (st *ssiTxn) addReadRange(r readRange) {
+	st.Lock()
+	st.readRanges = append(st.readRanges, r)
+	st.Unlock()
+}
+
+// rollback drops the reads done after st had read readsLen keys and readRangesLen ranges.
+func (st *ssiTxn) rollback(readsLen, readRangesLen int) {
+	st.Lock()
+	st.reads = st.reads[:readsLen]
+	st.readRanges = st.readRanges[:readRangesLen]
+	st.Unlock()
+}
+
+// numReads returns the number of keys and ranges read so far.
+func (st *ssiTxn) numReads() (int, int) {
+	st.Lock()
+	defer st.Unlock()
+	return len(st.reads), len(st.readRanges)
+}
+
+// readAny returns true if st read any of the written keys.
+func (st *ssiTxn) readAny(writes map[uint64]struct{}, keys [][]byte) bool {
+	st.Lock()
+	defer st.Unlock()
+	for _, fp := range st.reads {
+		if _, ok := writes[fp]; ok {
+			return true
+		}
+	}
+	for _, r := range st.readRanges {
+		if r.overlaps(keys) {
+			return true
+		}
+	}
+	return false
+}
+
+// readKeys returns those of the sorted keys which st read, directly or within the ranges covered by
+// its iterators.
+func (st *ssiTxn) readKeys(keys [][]byte) [][]byte {
+	st.Lock()
+	defer st.Unlock()
+	reads := make(map[uint64]struct{}, len(st.reads))
+	for _, fp := range st.reads {
+		reads[fp] = struct{}{}
+	}
+	var out [][]byte
+	for _, key := range keys {
+		if _, ok := reads[farm.Fingerprint64(key)]; ok {
+			out = append(out, key)
+			continue
+		}
+		for _, r := range st.readRanges {
+			if r.contains(key) {
+				out = append(out, key)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func (o *oracle) addSSITxn(st *ssiTxn) {
+	o.Lock()
+	defer o.Unlock()
+	o.ssiRunning[st] = struct{}{}
+}
+
+func (o *oracle) removeSSITxn(st *ssiTxn) {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.ssiRunning, st)
+}
+
+// ssiConflict returns an error if committing the txn would make it, or a committed txn, a pivot.
+// Otherwise, it records the dependencies of the txn. It must be called while having a lock, and
+// keys are the sorted keys written by the txn.
+func (o *oracle) ssiConflict(txn *Txn, keys [][]byte) error {
+	st := txn.ssi
+	writes := make(map[uint64]struct{}, len(txn.writes))
+	for _, fp := range txn.writes {
+		writes[fp] = struct{}{}
+	}
+
+	// Dependencies out of the txn go to the concurrent txns which committed writes it read.
+	var outs, ins []*ssiTxn
+	for _, w := range o.ssiCommitted {
+		if w.commitTs > txn.readTs && st.readAny(w.writes, w.keys) {
+			outs = append(outs, w)
+		}
+	}
+	// Dependencies into the txn come from the concurrent txns which read keys it writes.
+	if len(writes) > 0 {
+		for _, r := range o.ssiCommitted {
+			if r.commitTs > txn.readTs && r.readAny(writes, keys) {
+				ins = append(ins, r)
+			}
+		}
+		for r := range o.ssiRunning {
+			if r != st && r.readAny(writes, keys) {
+				ins = append(ins, r)
+			}
+		}
+	}
+
+	in, out := st.in || len(ins) > 0, st.out || len(outs) > 0
+	if in && out {
+		return ssiConflictError(txn, keys, outs, ins)
+	}
+	for _, w := range outs {
+		if w.out {
+			// W would be a pivot, and it has committed already.
+			return ssiConflictError(txn, keys, outs, ins)
+		}
+	}
+	for _, r := range ins {
+		if r.commitTs > 0 && r.in {
+			return ssiConflictError(txn, keys, outs, ins) // Same for R.
+		}
+	}
+	// A running R which becomes a pivot here would be aborted on its commit instead.
+	for _, w := range outs {
+		w.in = true
+	}
+	for _, r := range ins {
+		r.out = true
+	}
+	st.in, st.out = in, out
+	st.writes, st.keys = writes, keys
+	return nil
+}
+
+// ssiConflictError returns ErrConflict, or with Options.KeepConflictKeys, a *ConflictError naming
+// the keys behind the dependencies of the txn: those it read, which were written by the txns in
+// outs, and those it wrote, which were read by the txns in ins.
+func ssiConflictError(txn *Txn, keys [][]byte, outs, ins []*ssiTxn) error {
+	if !txn.db.opt.KeepConflictKeys {
+		return ErrConflict
+	}
+	cerr := &ConflictError{}
+	seen := make(map[string]struct{})
+	add := func(keys [][]byte, ts uint64) {
+		for _, key := range keys {
+			if _, ok := seen[string(key)]; !ok {
+				seen[string(key)] = struct{}{}
+				cerr.Keys = append(cerr.Keys, key)
+			}
+		}
+		if len(keys) > 0 && ts > cerr.CommitTs {
+			cerr.CommitTs = ts
+		}
+	}
+	for _, w := range outs {
+		add(txn.ssi.readKeys(w.keys), w.commitTs)
+	}
+	for _, r := range ins {
+		add(r.readKeys(keys), r.commitTs)
+	}
+	sort.Slice(cerr.Keys, func(i, j int) bool {
+		return bytes.Compare(cerr.Keys[i], cerr.Keys[j]) < 0
+	})
+	return cerr
+}
+
+// ssiCommit records the txn as committed at ts. It must be called while having a lock.
+func (o *oracle) ssiCommit(st *ssiTxn, ts uint64) {
+	st.commitTs = ts
+	delete(o.ssiRunning, st)
+	o.ssiCommitted = append(o.ssiCommitted, st)
+}
@@ -31,8 +31,7 @@ const pageSize = 4 << 20 // 4MB
 
 // Stream provides a framework to concurrently iterate over a snapshot of Badger, pick up
 // key-values, batch them up and call Send. Stream does concurrent iteration over many smaller key
-// ranges. It does NOT send keys in lexicographical sorted order. To get keys in sorted
-// order, use Iterator.
+// ranges. Unless Ordered is set, it does NOT send keys in lexicographical sorted order.
 type Stream struct {
 	// Prefix to only iterate over certain range of keys. If set to nil (default), Stream would
 	// iterate over the entire DB.
@@ -41,6 +40,12 @@ type Stream struct {
 	// Number of goroutines to use for iterating over key ranges. Defaults to 16.
 	NumGo int
 
+	// Ordered makes Send receive the keys in lexicographical sorted order. The key ranges are
+	// still iterated over concurrently, but the KV lists of each range are held back until all the
+	// ranges before it have been sent. To bound the buffering, the goroutine iterating over a range
+	// waits once it's a couple of KV lists ahead.
+	Ordered bool
+
 	// Badger would produce log entries in Infof to indicate the progress of Stream. LogPrefix can
 	// be used to help differentiate them from other activities. Default is "Badger.Stream".
 	LogPrefix string
@@ -68,8 +73,16 @@ type Stream struct {
 
 	readTs  uint64
 	db      *DB
-	rangeCh chan keyRange
+	rangeCh chan streamRange
 	kvChan  chan *pb.KVList
+	orderCh chan chan *pb.KVList // Used if Ordered, to pass the kvChans of the ranges in order.
+}
+
+// streamRange is a key range to be iterated over by produceKVs, which sends the KV lists to
+// kvChan. If Stream.Ordered is set, each range has its own kvChan, which is closed once done.
+type streamRange struct {
+	keyRange
+	kvChan chan *pb.KVList
 }
 
 // ToList is a default implementation of KeyToList. It picks up all valid versions of the key,
@@ -112,16 +125,39 @@ func (st *Stream) ToList(key []byte, itr *Iterator) (*pb.KVList, error) {
 // keyRange is [start, end), including start, excluding end. Do ensure that the start,
 // end byte slices are owned by keyRange struct.
 func (st *Stream) produceRanges(ctx context.Context) {
+	defer close(st.rangeCh)
+	if st.Ordered {
+		defer close(st.orderCh)
+	}
+	send := func(kr keyRange) bool {
+		sr := streamRange{keyRange: kr, kvChan: st.kvChan}
+		if st.Ordered {
+			sr.kvChan = make(chan *pb.KVList, 1)
+			select {
+			case st.orderCh <- sr.kvChan:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		select {
+		case st.rangeCh <- sr:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
 	splits := st.db.KeySplits(st.Prefix)
 	start := y.SafeCopy(nil, st.Prefix)
 	for _, key := range splits {
-		st.rangeCh <- keyRange{left: start, right: y.SafeCopy(nil, []byte(key))}
+		if !send(keyRange{left: start, right: y.SafeCopy(nil, []byte(key))}) {
+			return
+		}
 		start = y.SafeCopy(nil, []byte(key))
 	}
 	// Edge case: prefix is empty and no splits exist. In that case, we should have at least one
 	// keyRange output.
-	st.rangeCh <- keyRange{left: start}
-	close(st.rangeCh)
+	send(keyRange{left: start})
 }
 
 // produceKVs picks up ranges from rangeCh, generates KV lists and sends them to kvChan.
@@ -135,7 +171,10 @@ func (st *Stream) produceKVs(ctx context.Context) error {
 	}
 	defer txn.Discard()
 
-	iterate := func(kr keyRange) error {
+	iterate := func(kr streamRange) error {
+		if st.Ordered {
+			defer close(kr.kvChan)
+		}
 		iterOpts := DefaultIteratorOptions
 		iterOpts.AllVersions = true
 		iterOpts.Prefix = st.Prefix
@@ -143,6 +182,15 @@ func (st *Stream) produceKVs(ctx context.Context) error {
 		itr := txn.NewIterator(iterOpts)
 		defer itr.Close()
 
+		send := func(list *pb.KVList) error {
+			select {
+			case kr.kvChan <- list:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
 		outList := new(pb.KVList)
 		var prevKey []byte
 		for itr.Seek(kr.left); itr.Valid(); {
@@ -174,13 +222,15 @@ func (st *Stream) produceKVs(ctx context.Context) error {
 			outList.Kv = append(outList.Kv, list.Kv...)
 			size += list.Size()
 			if size >= pageSize {
-				st.kvChan <- outList
+				if err := send(outList); err != nil {
+					return err
+				}
 				outList = new(pb.KVList)
 				size = 0
 			}
 		}
 		if len(outList.Kv) > 0 {
-			st.kvChan <- outList
+			return send(outList)
 		}
 		return nil
 	}
@@ -208,11 +258,18 @@ func (st *Stream) streamKVs(ctx context.Context) error {
 	defer t.Stop()
 	now := time.Now()
 
+	// kvChan is where the KV lists are picked up from. If Ordered, that's the kvChan of the range
+	// being sent, and nil in between ranges.
+	kvChan := st.kvChan
+	if st.Ordered {
+		kvChan = nil
+	}
+
 	slurp := func(batch *pb.KVList) error {
 	loop:
 		for {
 			select {
-			case kvs, ok := <-st.kvChan:
+			case kvs, ok := <-kvChan:
 				if !ok {
 					break loop
 				}
@@ -236,6 +293,19 @@ func (st *Stream) streamKVs(ctx context.Context) error {
 
 outer:
 	for {
+		if st.Ordered && kvChan == nil {
+			// Move on to the next range.
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case next, ok := <-st.orderCh:
+				if !ok {
+					break outer
+				}
+				kvChan = next
+			}
+		}
+
 		var batch *pb.KVList
 		select {
 		case <-ctx.Done():
@@ -251,8 +321,12 @@ outer:
 			Infof("%s Time elapsed: %s, bytes sent: %s, speed: %s/sec\n", st.LogPrefix,
 				y.FixedDuration(dur), humanize.Bytes(bytesSent), humanize.Bytes(speed))
 
-		case kvs, ok := <-st.kvChan:
+		case kvs, ok := <-kvChan:
 			if !ok {
+				if st.Ordered {
+					kvChan = nil
+					continue
+				}
 				break outer
 			}
 			y.AssertTrue(kvs != nil)
@@ -274,12 +348,20 @@ outer:
 // are serial. In case any of these steps encounter an error, Orchestrate would stop execution and
 // return that error. Orchestrate can be called multiple times, but in serial order.
 func (st *Stream) Orchestrate(ctx context.Context) error {
-	st.rangeCh = make(chan keyRange, 3) // Contains keys for posting lists.
+	// On the first error, the other goroutines are stopped via ctx.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	st.rangeCh = make(chan streamRange, 3) // Contains keys for posting lists.
 
 	// kvChan should only have a small capacity to ensure that we don't buffer up too much data if
 	// sending is slow. Page size is set to 4MB, which is used to lazily cap the size of each
 	// KVList. To get around 64MB buffer, we can set the channel size to 16.
 	st.kvChan = make(chan *pb.KVList, 16)
+	if st.Ordered {
+		// Only the ranges being iterated over, or waiting to be, have their KV lists buffered.
+		st.orderCh = make(chan chan *pb.KVList, st.NumGo)
+	}
 
 	if st.KeyToList == nil {
 		st.KeyToList = st.ToList
@@ -288,7 +370,14 @@ func (st *Stream) Orchestrate(ctx context.Context) error {
 	// Picks up ranges from Badger, and sends them to rangeCh.
 	go st.produceRanges(ctx)
 
-	errCh := make(chan error, 1) // Stores error by consumeKeys.
+	errCh := make(chan error, 1) // Stores the first error by produceKVs or streamKVs.
+	setErr := func(err error) {
+		select {
+		case errCh <- err:
+		default:
+		}
+		cancel()
+	}
 	var wg sync.WaitGroup
 	for i := 0; i < st.NumGo; i++ {
 		wg.Add(1)
@@ -296,34 +385,30 @@ func (st *Stream) Orchestrate(ctx context.Context) error {
 			defer wg.Done()
 			// Picks up ranges from rangeCh, generates KV lists, and sends them to kvChan.
 			if err := st.produceKVs(ctx); err != nil {
-				select {
-				case errCh <- err:
-				default:
-				}
+				setErr(err)
 			}
 		}()
 	}
 
 	// Pick up key-values from kvChan and send to stream.
-	kvErr := make(chan error, 1)
+	kvDone := make(chan struct{})
 	go func() {
+		defer close(kvDone)
 		// Picks up KV lists from kvChan, and sends them to Output.
-		kvErr <- st.streamKVs(ctx)
+		if err := st.streamKVs(ctx); err != nil {
+			setErr(err)
+		}
 	}()
 	wg.Wait()        // Wait for produceKVs to be over.
 	close(st.kvChan) // Now we can close kvChan.
+	<-kvDone         // Wait for key streaming to be over.
 
 	select {
-	case err := <-errCh: // Check error from produceKVs.
+	case err := <-errCh:
 		return err
 	default:
+		return nil
 	}
-
-	// Wait for key streaming to be over.
-	if err := <-kvErr; err != nil {
-		return err
-	}
-	return nil
 }
 
 func (db *DB) newStream() *Stream {
@@ -18,6 +18,7 @@ package badger
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -167,3 +168,33 @@ func TestStream(t *testing.T) {
 		require.Equal(t, 50, count, "Count mismatch for pred: %s", pred)
 	}
 }
+
+func TestStreamOrdered(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		N := 5000
+		batch := db.NewWriteBatch()
+		for i := 0; i < N; i++ {
+			require.NoError(t, batch.Set([]byte(fmt.Sprintf("%06d", i)), make([]byte, 100), 0))
+		}
+		require.NoError(t, batch.Flush())
+		require.True(t, len(db.KeySplits(nil)) > 1, "Expected the keys to span many ranges.")
+
+		stream := db.NewStream()
+		stream.LogPrefix = "Testing"
+		stream.Ordered = true
+		c := &collector{}
+		stream.Send = c.Send
+		require.NoError(t, stream.Orchestrate(ctxb))
+		require.Equal(t, N, len(c.kv))
+		for i, kv := range c.kv {
+			require.Equal(t, fmt.Sprintf("%06d", i), string(kv.Key))
+		}
+
+		// An error from Send stops the stream.
+		errSend := errors.New("send failed")
+		stream.Send = func(list *bpb.KVList) error {
+			return errSend
+		}
+		require.Equal(t, errSend, stream.Orchestrate(ctxb))
+	})
+}
@@ -0,0 +1,311 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync"
+
+	"github.com/dgraph-io/badger/pb"
+	"github.com/dgraph-io/badger/table"
+	"github.com/dgraph-io/badger/y"
+	"github.com/pkg/errors"
+)
+
+// Number of tables a StreamWriter writes out concurrently.
+const numStreamWriterTables = 8
+
+// StreamWriter builds a DB directly from a sorted stream of KVs, like the one sent by a Stream with
+// Ordered set. Unlike DB.Load, it doesn't replay the KVs through the memtables. The keys are
+// written straight into SSTables, which are added to the bottom level of the LSM tree by Flush.
+// The values which don't fit in the SSTables still go to the value log.
+//
+// StreamWriter replaces all the data in the DB. Between Prepare and Flush (or Cancel), the DB can't
+// be written to, and shouldn't be read from.
+type StreamWriter struct {
+	db         *DB
+	resume     func() // Resumes the writes to the DB, paused by Prepare.
+	builder    *table.Builder
+	lastKey    []byte       // Last key added, with its timestamp.
+	maxVersion uint64       // Highest version written.
+	head       valuePointer // End of the values written to the value log.
+
+	throttle chan struct{} // Limits the number of tables being written out at once.
+	wg       sync.WaitGroup
+
+	sync.Mutex                // Guards the following, set by the goroutines writing tables.
+	tables     []*table.Table // Tables written out so far.
+	err        error          // First error hit while writing a table.
+}
+
+// NewStreamWriter creates a StreamWriter. Prepare must be called before writing to it.
+func (db *DB) NewStreamWriter() *StreamWriter {
+	return &StreamWriter{
+		db:       db,
+		throttle: make(chan struct{}, numStreamWriterTables),
+	}
+}
+
+// Prepare drops all the data in the DB, like DropAll. The writes to the DB, memtable flushes and
+// compactions stay paused until Flush or Cancel is called.
+func (sw *StreamWriter) Prepare() error {
+	resume, err := sw.db.dropAll()
+	if err != nil {
+		resume()
+		return err
+	}
+	sw.resume = resume
+	return nil
+}
+
+// Write adds the KVs to the DB. Across all calls, the KVs must be sorted by key, and the versions of
+// a key must be in descending order, as sent by Stream.
+func (sw *StreamWriter) Write(kvs *pb.KVList) error {
+	if sw.resume == nil {
+		panic("StreamWriter.Prepare must be called before writing.")
+	}
+	entries := make([]*Entry, 0, len(kvs.Kv))
+	req := &request{}
+	lastKey := sw.lastKey
+	for _, kv := range kvs.Kv {
+		key := y.KeyWithTs(kv.Key, kv.Version)
+		if len(lastKey) > 0 && y.CompareKeys(key, lastKey) <= 0 {
+			return errors.Errorf("Keys are not sorted: %q at version %d comes after %q at version %d",
+				kv.Key, kv.Version, y.ParseKey(lastKey), y.ParseTs(lastKey))
+		}
+		lastKey = key
+
+		e := &Entry{Key: key, Value: kv.Value, ExpiresAt: kv.ExpiresAt}
+		if len(kv.UserMeta) > 0 {
+			e.UserMeta = kv.UserMeta[0]
+		}
+		if len(kv.Meta) > 0 {
+			// The KVs aren't part of txns, and the value pointers are ours to set.
+			e.meta = kv.Meta[0] &^ (bitValuePointer | bitTxn | bitFinTxn)
+		}
+		entries = append(entries, e)
+		if !sw.db.shouldWriteValueToLSM(*e) {
+			req.Entries = append(req.Entries, e)
+		}
+		if kv.Version > sw.maxVersion {
+			sw.maxVersion = kv.Version
+		}
+	}
+
+	if len(req.Entries) > 0 {
+		if err := sw.db.vlog.write([]*request{req}); err != nil {
+			return err
+		}
+		last := req.Ptrs[len(req.Ptrs)-1]
+		sw.head = valuePointer{Fid: last.Fid, Offset: last.Offset + last.Len}
+	}
+
+	var ptrIdx int
+	for _, e := range entries {
+		vs := y.ValueStruct{
+			Value:     e.Value,
+			Meta:      e.meta,
+			UserMeta:  e.UserMeta,
+			ExpiresAt: e.ExpiresAt,
+		}
+		if !sw.db.shouldWriteValueToLSM(*e) {
+			vs.Value = req.Ptrs[ptrIdx].Encode(make([]byte, vptrSize))
+			ptrIdx++
+			vs.Meta |= bitValuePointer
+		}
+		if err := sw.add(e.Key, vs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds the key to the table being built. Once the table has reached its capacity, it's written
+// out, and a new one is started. All the versions of a key are kept in the same table.
+func (sw *StreamWriter) add(key []byte, vs y.ValueStruct) error {
+	if sw.builder != nil && !y.SameKey(key, sw.lastKey) &&
+		sw.builder.ReachedCapacity(sw.db.opt.MaxTableSize) {
+		if err := sw.finishTable(); err != nil {
+			return err
+		}
+	}
+	if sw.builder == nil {
+		sw.builder = table.NewTableBuilder()
+	}
+	sw.lastKey = key
+	return sw.builder.Add(key, vs, vs.Meta&bitDelete > 0)
+}
+
+// finishTable writes out the table being built in the background.
+func (sw *StreamWriter) finishTable() error {
+	sw.Lock()
+	err := sw.err
+	sw.Unlock()
+	if err != nil {
+		return err
+	}
+
+	builder := sw.builder
+	sw.builder = nil
+	if builder == nil || builder.Empty() {
+		return nil
+	}
+	sw.throttle <- struct{}{}
+	sw.wg.Add(1)
+	go func() {
+		defer func() {
+			<-sw.throttle
+			sw.wg.Done()
+		}()
+		tbl, err := sw.writeTable(builder)
+
+		sw.Lock()
+		defer sw.Unlock()
+		if tbl != nil {
+			sw.tables = append(sw.tables, tbl)
+		}
+		if sw.err == nil {
+			sw.err = err
+		}
+	}()
+	return nil
+}
+
+func (sw *StreamWriter) writeTable(builder *table.Builder) (*table.Table, error) {
+	defer builder.Close()
+
+	fileID := sw.db.lc.reserveFileID()
+	fd, err := y.CreateSyncedFile(table.NewFilename(fileID, sw.db.opt.Dir), true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "While opening new table: %d", fileID)
+	}
+	if _, err := fd.Write(builder.Finish()); err != nil {
+		_ = fd.Close()
+		return nil, errors.Wrapf(err, "Unable to write to file: %d", fileID)
+	}
+	tbl, err := table.OpenTable(fd, sw.db.opt.TableLoadingMode)
+	return tbl, errors.Wrapf(err, "Unable to open table: %q", fd.Name())
+}
+
+// Flush writes out the rest of the tables, and installs them into the DB with a single manifest
+// change. The tables holding the KVs go into the bottom level, and the one holding the value log
+// head into level 0. Then, the writes to the DB are resumed. If there's an error, the DB is left
+// empty, as after Cancel.
+func (sw *StreamWriter) Flush() error {
+	if sw.resume == nil {
+		panic("StreamWriter.Prepare must be called before flushing.")
+	}
+	if err := sw.flush(); err != nil {
+		if cerr := sw.Cancel(); cerr != nil {
+			Errorf("While cancelling the StreamWriter: %v", cerr)
+		}
+		return err
+	}
+	sw.resume()
+	sw.resume = nil
+	return nil
+}
+
+func (sw *StreamWriter) flush() error {
+	if err := sw.finishTable(); err != nil {
+		return err
+	}
+
+	// Make the versions written visible to the new reads. Like a memtable flush, store the head
+	// with the latest version, so that it's picked up again when the DB is opened.
+	orc := sw.db.orc
+	orc.Lock()
+	newVersions := orc.nextTxnTs <= sw.maxVersion
+	if newVersions {
+		orc.nextTxnTs = sw.maxVersion + 1
+	}
+	headTs := orc.nextTxnTs - 1
+	orc.Unlock()
+
+	sw.builder = table.NewTableBuilder()
+	if err := sw.builder.Add(y.KeyWithTs(head, headTs),
+		y.ValueStruct{Value: sw.head.Encode(make([]byte, vptrSize))}, false); err != nil {
+		return err
+	}
+	headTable, err := sw.writeTable(sw.builder)
+	sw.builder = nil
+	if err != nil {
+		return err
+	}
+	defer headTable.DecrRef()
+
+	sw.wg.Wait()
+	if sw.err != nil {
+		return sw.err
+	}
+	if err := sw.db.vlog.sync(); err != nil {
+		return err
+	}
+	if err := syncDir(sw.db.opt.Dir); err != nil {
+		return err
+	}
+
+	bottom := sw.db.lc.levels[len(sw.db.lc.levels)-1]
+	changes := []*pb.ManifestChange{makeTableCreateChange(headTable.ID(), 0)}
+	for _, tbl := range sw.tables {
+		changes = append(changes, makeTableCreateChange(tbl.ID(), bottom.level))
+	}
+	if err := sw.db.manifest.addChanges(changes); err != nil {
+		return err
+	}
+	if err := bottom.replaceTables(nil, sw.tables); err != nil {
+		return err
+	}
+	if err := sw.db.lc.levels[0].replaceTables(nil, []*table.Table{headTable}); err != nil {
+		return err
+	}
+	err = decrRefs(sw.tables) // The levels hold their own references.
+	sw.tables = nil
+	if err != nil {
+		return err
+	}
+
+	sw.db.vhead = sw.head
+	if newVersions {
+		orc.txnMark.Done(headTs)
+	}
+	return nil
+}
+
+// Cancel stops the StreamWriter without installing the tables written, drops the values written to
+// the value log, and resumes the writes to the DB, which stays empty.
+func (sw *StreamWriter) Cancel() error {
+	if sw.resume == nil {
+		return nil
+	}
+	defer func() {
+		sw.resume()
+		sw.resume = nil
+	}()
+
+	sw.wg.Wait()
+	if sw.builder != nil {
+		sw.builder.Close()
+		sw.builder = nil
+	}
+	// The tables are deleted once their last reference is gone.
+	err := decrRefs(sw.tables)
+	sw.tables = nil
+	if _, verr := sw.db.vlog.dropAll(); err == nil {
+		err = verr
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	bpb "github.com/dgraph-io/badger/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamWriter(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "badger-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	// Dropped by Prepare.
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("old"), []byte("old"))
+	}))
+
+	// Every key has two versions. The values of the even keys go to the value log.
+	N := 3000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%06d", i)) }
+	val := func(i int, version uint64) []byte {
+		if i%2 == 0 {
+			return bytes.Repeat([]byte{byte(version)}, 100)
+		}
+		return []byte(fmt.Sprintf("%d-%d", i, version))
+	}
+
+	sw := db.NewStreamWriter()
+	require.NoError(t, sw.Prepare())
+	list := &bpb.KVList{}
+	for i := 0; i < N; i++ {
+		for _, version := range []uint64{20, 10} {
+			list.Kv = append(list.Kv, &bpb.KV{Key: key(i), Value: val(i, version), Version: version})
+		}
+		if len(list.Kv) >= 100 {
+			require.NoError(t, sw.Write(list))
+			list = &bpb.KVList{}
+		}
+	}
+	require.NoError(t, sw.Write(list))
+	require.NoError(t, sw.Flush())
+
+	// The tables went to the bottom level, except for the one holding the value log head.
+	var numTables int
+	for _, ti := range db.Tables() {
+		if ti.Level != 0 {
+			require.Equal(t, len(db.lc.levels)-1, ti.Level)
+			numTables++
+		}
+	}
+	require.True(t, numTables > 1, "Expected many tables. Got: %d", numTables)
+	require.Equal(t, 1, len(db.lc.levels[0].tables))
+
+	check := func(t *testing.T, db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("old"))
+			require.Equal(t, ErrKeyNotFound, err)
+
+			opt := DefaultIteratorOptions
+			opt.AllVersions = true
+			opt.UpperBound = []byte("a") // Leave out the other keys written below.
+			itr := txn.NewIterator(opt)
+			defer itr.Close()
+			var count int
+			for itr.Rewind(); itr.Valid(); itr.Next() {
+				item := itr.Item()
+				i, version := count/2, uint64(20-10*(count%2))
+				require.Equal(t, key(i), item.Key())
+				require.Equal(t, version, item.Version())
+				v, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, val(i, version), v)
+				count++
+			}
+			require.Equal(t, 2*N, count)
+			return nil
+		}))
+	}
+	check(t, db)
+
+	// New writes go on from the versions written.
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("new"), []byte("new"))
+	}))
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("new"))
+		require.NoError(t, err)
+		require.True(t, item.Version() > 20)
+		return nil
+	}))
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Delete([]byte("new"))
+	}))
+
+	// Everything is found again after reopening the DB.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	check(t, db)
+}
+
+func TestStreamWriterUnsorted(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		sw := db.NewStreamWriter()
+		require.NoError(t, sw.Prepare())
+		require.NoError(t, sw.Write(&bpb.KVList{Kv: []*bpb.KV{
+			{Key: []byte("a"), Value: bytes.Repeat([]byte("a"), 100), Version: 1},
+			{Key: []byte("b"), Value: []byte("b"), Version: 2},
+			{Key: []byte("b"), Value: []byte("b"), Version: 1},
+		}}))
+		err := sw.Write(&bpb.KVList{Kv: []*bpb.KV{
+			{Key: []byte("a"), Value: []byte("a"), Version: 2},
+		}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not sorted")
+		require.NoError(t, sw.Cancel())
+
+		// The DB is left empty, and can be written to again.
+		require.Empty(t, db.Tables())
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("c"), []byte("c"))
+		}))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			itr := txn.NewIterator(DefaultIteratorOptions)
+			defer itr.Close()
+			var keys []string
+			for itr.Rewind(); itr.Valid(); itr.Next() {
+				keys = append(keys, string(itr.Item().Key()))
+			}
+			require.Equal(t, []string{"c"}, keys)
+			return nil
+		}))
+	})
+}
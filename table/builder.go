@@ -80,6 +80,8 @@ type Builder struct {
 
 	keyBuf   *bytes.Buffer
 	keyCount int
+
+	stats Stats
 }
 
 // NewTableBuilder makes a new TableBuilder.
@@ -154,9 +156,9 @@ func (b *Builder) finishBlock() {
 	b.addHelper([]byte{}, y.ValueStruct{})
 }
 
-// Add adds a key-value pair to the block.
-// If doNotRestart is true, we will not restart even if b.counter >= restartInterval.
-func (b *Builder) Add(key []byte, value y.ValueStruct) error {
+// Add adds a key-value pair to the block. A new block is started every restartInterval entries.
+// deleted tells whether the value is a delete marker, which is counted in the table's Stats.
+func (b *Builder) Add(key []byte, value y.ValueStruct, deleted bool) error {
 	if b.counter >= restartInterval {
 		b.finishBlock()
 		// Start a new block. Initialize the block.
@@ -167,6 +169,19 @@ func (b *Builder) Add(key []byte, value y.ValueStruct) error {
 		b.prevOffset = math.MaxUint32 // First key-value pair of block has header.prev=MaxInt.
 	}
 	b.addHelper(key, value)
+	if deleted {
+		b.stats.NumDeletes++
+	}
+	if value.ExpiresAt > 0 {
+		st := &b.stats
+		if st.NumExpiring == 0 || value.ExpiresAt < st.MinExpiresAt {
+			st.MinExpiresAt = value.ExpiresAt
+		}
+		if value.ExpiresAt > st.MaxExpiresAt {
+			st.MaxExpiresAt = value.ExpiresAt
+		}
+		st.NumExpiring++
+	}
 	return nil // Currently, there is no meaningful error.
 }
 
@@ -221,13 +236,23 @@ func (b *Builder) Finish() []byte {
 
 	b.finishBlock() // This will never start a new block.
 	index := b.blockIndex()
+
+	// Write stats, followed by their length and statsMagic, between the blocks and the index. The
+	// index records where the blocks end, so readers which don't know about stats skip them.
+	b.stats.NumEntries = uint64(b.keyCount)
+	sdata := b.stats.encode()
+	b.buf.Write(sdata)
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(len(sdata)))
+	b.buf.Write(buf[:])
+	b.buf.Write(statsMagic)
+
 	b.buf.Write(index)
 
 	// Write bloom filter.
 	bdata := bf.JSONMarshal()
 	n, err := b.buf.Write(bdata)
 	y.Check(err)
-	var buf [4]byte
 	binary.BigEndian.PutUint32(buf[:], uint32(n))
 	b.buf.Write(buf[:])
 
@@ -197,8 +197,10 @@ type Iterator struct {
 	bi   *blockIterator
 	err  error
 
+	blocksRead int64 // Number of blocks loaded, for BlocksRead.
+
 	// Internally, Iterator is bidirectional. However, we only expose the
-	// unidirectional functionality for now.
+	// unidirectional functionality, whose direction can be changed via SetReversed.
 	reversed bool
 }
 
@@ -210,6 +212,17 @@ func (t *Table) NewIterator(reversed bool) *Iterator {
 	return ti
 }
 
+// block loads the block at idx, and counts it.
+func (itr *Iterator) block(idx int) (block, error) {
+	itr.blocksRead++
+	return itr.t.block(idx)
+}
+
+// BlocksRead returns the number of blocks the iterator has loaded so far.
+func (itr *Iterator) BlocksRead() int64 {
+	return itr.blocksRead
+}
+
 // Close closes the iterator (and it must be called).
 func (itr *Iterator) Close() error {
 	return itr.t.DecrRef()
@@ -232,7 +245,7 @@ func (itr *Iterator) seekToFirst() {
 		return
 	}
 	itr.bpos = 0
-	block, err := itr.t.block(itr.bpos)
+	block, err := itr.block(itr.bpos)
 	if err != nil {
 		itr.err = err
 		return
@@ -249,7 +262,7 @@ func (itr *Iterator) seekToLast() {
 		return
 	}
 	itr.bpos = numBlocks - 1
-	block, err := itr.t.block(itr.bpos)
+	block, err := itr.block(itr.bpos)
 	if err != nil {
 		itr.err = err
 		return
@@ -261,7 +274,7 @@ func (itr *Iterator) seekToLast() {
 
 func (itr *Iterator) seekHelper(blockIdx int, key []byte) {
 	itr.bpos = blockIdx
-	block, err := itr.t.block(blockIdx)
+	block, err := itr.block(blockIdx)
 	if err != nil {
 		itr.err = err
 		return
@@ -334,7 +347,7 @@ func (itr *Iterator) next() {
 	}
 
 	if itr.bi == nil {
-		block, err := itr.t.block(itr.bpos)
+		block, err := itr.block(itr.bpos)
 		if err != nil {
 			itr.err = err
 			return
@@ -362,7 +375,7 @@ func (itr *Iterator) prev() {
 	}
 
 	if itr.bi == nil {
-		block, err := itr.t.block(itr.bpos)
+		block, err := itr.block(itr.bpos)
 		if err != nil {
 			itr.err = err
 			return
@@ -420,6 +433,11 @@ func (itr *Iterator) Seek(key []byte) {
 	}
 }
 
+// SetReversed follows the y.Iterator interface
+func (itr *Iterator) SetReversed(reversed bool) {
+	itr.reversed = reversed
+}
+
 // ConcatIterator concatenates the sequences defined by several iterators.  (It only works with
 // TableIterators, probably just because it's faster to not be so generic.)
 type ConcatIterator struct {
@@ -528,6 +546,23 @@ func (s *ConcatIterator) Next() {
 	}
 }
 
+// SetReversed implements y.Interface
+func (s *ConcatIterator) SetReversed(reversed bool) {
+	s.reversed = reversed
+	for _, it := range s.iters {
+		it.SetReversed(reversed)
+	}
+}
+
+// BlocksRead returns the number of blocks loaded so far, over all the tables.
+func (s *ConcatIterator) BlocksRead() int64 {
+	var n int64
+	for _, it := range s.iters {
+		n += it.BlocksRead()
+	}
+	return n
+}
+
 // Close implements y.Interface.
 func (s *ConcatIterator) Close() error {
 	for _, it := range s.iters {
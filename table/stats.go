@@ -0,0 +1,78 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import "encoding/binary"
+
+// statsMagic follows the stats and their length, which are stored between the last block and the
+// index. Tables written before stats were introduced have no gap there, so they're told apart by
+// the end of the last block, as recorded in the index. Readers which don't know about stats only
+// go by the index, so they can still open tables which have them.
+var statsMagic = []byte("!bstats!")
+
+// Stats are collected while building a table, and stored in it alongside the index.
+type Stats struct {
+	NumEntries   uint64 // Number of key-value pairs, counting every version.
+	NumDeletes   uint64 // Number of delete markers.
+	NumExpiring  uint64 // Number of key-value pairs with an expiry time.
+	MinExpiresAt uint64 // Earliest expiry time, as a unix timestamp.
+	MaxExpiresAt uint64 // Latest expiry time, as a unix timestamp.
+}
+
+func (s *Stats) fields() []*uint64 {
+	return []*uint64{&s.NumEntries, &s.NumDeletes, &s.NumExpiring, &s.MinExpiresAt, &s.MaxExpiresAt}
+}
+
+func (s *Stats) encode() []byte {
+	fields := s.fields()
+	buf := make([]byte, 8*len(fields))
+	for i, f := range fields {
+		binary.BigEndian.PutUint64(buf[8*i:], *f)
+	}
+	return buf
+}
+
+// decode reads back the fields written by encode. Any trailing bytes are ignored, so more fields
+// can be added later on.
+func (s *Stats) decode(buf []byte) {
+	for _, f := range s.fields() {
+		if len(buf) < 8 {
+			return
+		}
+		*f = binary.BigEndian.Uint64(buf)
+		buf = buf[8:]
+	}
+}
+
+// GarbageRatio returns the estimated fraction of entries in the table which are delete markers, or
+// have expired by now, a unix timestamp. Expiry times are assumed to be spread evenly between
+// MinExpiresAt and MaxExpiresAt.
+func (s Stats) GarbageRatio(now uint64) float64 {
+	if s.NumEntries == 0 {
+		return 0
+	}
+	garbage := float64(s.NumDeletes)
+	switch {
+	case s.NumExpiring == 0 || now < s.MinExpiresAt:
+	case now >= s.MaxExpiresAt:
+		garbage += float64(s.NumExpiring)
+	default:
+		garbage += float64(s.NumExpiring) * float64(now-s.MinExpiresAt) /
+			float64(s.MaxExpiresAt-s.MinExpiresAt)
+	}
+	return garbage / float64(s.NumEntries)
+}
@@ -17,6 +17,7 @@
 package table
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"os"
@@ -65,7 +66,8 @@ type Table struct {
 	smallest, biggest []byte // Smallest and largest keys.
 	id                uint64 // file id, part of filename
 
-	bf bbloom.Bloom
+	bf    bbloom.Bloom
+	stats Stats
 }
 
 // IncrRef increments the refcount (having to do with whether the file should be deleted)
@@ -214,6 +216,10 @@ func (t *Table) readIndex() error {
 	readPos -= 4
 	buf = t.readNoFail(readPos, 4)
 	restartsLen := int(binary.BigEndian.Uint32(buf))
+	if restartsLen == 0 || 4*restartsLen > readPos {
+		return errors.Errorf("Invalid block index of table %s, with %d blocks",
+			t.fd.Name(), restartsLen)
+	}
 
 	readPos -= 4 * restartsLen
 	buf = t.readNoFail(readPos, 4*restartsLen)
@@ -224,6 +230,18 @@ func (t *Table) readIndex() error {
 		buf = buf[4:]
 	}
 
+	// Read stats, found between the end of the last block and the index. Tables written before
+	// stats were introduced don't have them.
+	if blocksEnd := offsets[restartsLen-1]; readPos-blocksEnd >= len(statsMagic)+4 &&
+		bytes.Equal(t.readNoFail(readPos-len(statsMagic), len(statsMagic)), statsMagic) {
+		pos := readPos - len(statsMagic) - 4
+		statsLen := int(binary.BigEndian.Uint32(t.readNoFail(pos, 4)))
+		if statsLen > pos-blocksEnd {
+			return errors.Errorf("Invalid stats of table %s, of length %d", t.fd.Name(), statsLen)
+		}
+		t.stats.decode(t.readNoFail(pos-statsLen, statsLen))
+	}
+
 	// The last offset stores the end of the last block.
 	for i := 0; i < len(offsets); i++ {
 		var o int
@@ -317,6 +335,57 @@ func (t *Table) Smallest() []byte { return t.smallest }
 // Biggest is its biggest key, or nil if there are none
 func (t *Table) Biggest() []byte { return t.biggest }
 
+// BlockKeys returns the first key of every block in the table, in ascending order. These can be
+// used as cheap boundaries to divide up the key range covered by the table.
+func (t *Table) BlockKeys() [][]byte {
+	keys := make([][]byte, 0, len(t.blockIndex))
+	for _, ko := range t.blockIndex {
+		keys = append(keys, ko.key)
+	}
+	return keys
+}
+
+// Stats returns the stats collected when the table was built.
+func (t *Table) Stats() Stats { return t.stats }
+
+// EstimateRange returns the approximate size in bytes and number of entries of the keys in
+// [start, end), without timestamps. An empty end is unbounded. It goes by the block index, adding
+// up the blocks which overlap with the range. If the table's entry count fits blocks of
+// restartInterval entries, the last one holding the rest, that's what each block is counted as.
+// Otherwise, the entries are spread evenly over the blocks.
+func (t *Table) EstimateRange(start, end []byte) (size int64, count uint64) {
+	if bytes.Compare(y.ParseKey(t.biggest), start) < 0 {
+		return 0, 0
+	}
+	n := len(t.blockIndex)
+	perBlock, last := uint64(restartInterval), uint64(restartInterval/2)
+	if total := t.stats.NumEntries; total > 0 {
+		full := uint64(restartInterval * (n - 1))
+		if total > full && total <= full+perBlock {
+			last = total - full
+		} else {
+			perBlock = total / uint64(n)
+			last = perBlock + total%uint64(n)
+		}
+	}
+	for i, ko := range t.blockIndex {
+		if len(end) > 0 && bytes.Compare(y.ParseKey(ko.key), end) >= 0 {
+			break
+		}
+		// The block ends before the first key of the next block.
+		if i+1 < n && bytes.Compare(y.ParseKey(t.blockIndex[i+1].key), start) < 0 {
+			continue
+		}
+		size += int64(ko.len)
+		if i+1 < n {
+			count += perBlock
+		} else {
+			count += last
+		}
+	}
+	return size, count
+}
+
 // Filename is NOT the file name.  Just kidding, it is.
 func (t *Table) Filename() string { return t.fd.Name() }
 
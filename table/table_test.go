@@ -17,6 +17,8 @@
 package table
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"math/rand"
 	"os"
@@ -62,7 +64,7 @@ func buildTable(t *testing.T, keyValues [][]string) *os.File {
 	})
 	for _, kv := range keyValues {
 		y.AssertTrue(len(kv) == 2)
-		err := b.Add(y.KeyWithTs([]byte(kv[0]), 0), y.ValueStruct{Value: []byte(kv[1]), Meta: 'A', UserMeta: 0})
+		err := b.Add(y.KeyWithTs([]byte(kv[0]), 0), y.ValueStruct{Value: []byte(kv[1]), Meta: 'A', UserMeta: 0}, false)
 		if t != nil {
 			require.NoError(t, err)
 		} else {
@@ -632,7 +634,7 @@ func BenchmarkRead(b *testing.B) {
 	for i := 0; i < n; i++ {
 		k := fmt.Sprintf("%016x", i)
 		v := fmt.Sprintf("%d", i)
-		y.Check(builder.Add([]byte(k), y.ValueStruct{Value: []byte(v), Meta: 123, UserMeta: 0}))
+		y.Check(builder.Add([]byte(k), y.ValueStruct{Value: []byte(v), Meta: 123, UserMeta: 0}, false))
 	}
 
 	f.Write(builder.Finish())
@@ -662,7 +664,7 @@ func BenchmarkReadAndBuild(b *testing.B) {
 	for i := 0; i < n; i++ {
 		k := fmt.Sprintf("%016x", i)
 		v := fmt.Sprintf("%d", i)
-		y.Check(builder.Add([]byte(k), y.ValueStruct{Value: []byte(v), Meta: 123, UserMeta: 0}))
+		y.Check(builder.Add([]byte(k), y.ValueStruct{Value: []byte(v), Meta: 123, UserMeta: 0}, false))
 	}
 
 	f.Write(builder.Finish())
@@ -680,7 +682,7 @@ func BenchmarkReadAndBuild(b *testing.B) {
 			defer it.Close()
 			for it.seekToFirst(); it.Valid(); it.next() {
 				vs := it.Value()
-				newBuilder.Add(it.Key(), vs)
+				newBuilder.Add(it.Key(), vs, false)
 			}
 			newBuilder.Finish()
 		}()
@@ -703,7 +705,7 @@ func BenchmarkReadMerged(b *testing.B) {
 			// id := i*tableSize+j (not interleaved)
 			k := fmt.Sprintf("%016x", id)
 			v := fmt.Sprintf("%d", id)
-			y.Check(builder.Add([]byte(k), y.ValueStruct{Value: []byte(v), Meta: 123, UserMeta: 0}))
+			y.Check(builder.Add([]byte(k), y.ValueStruct{Value: []byte(v), Meta: 123, UserMeta: 0}, false))
 		}
 		f.Write(builder.Finish())
 		tbl, err := OpenTable(f, options.MemoryMap)
@@ -727,3 +729,112 @@ func BenchmarkReadMerged(b *testing.B) {
 		}()
 	}
 }
+
+func TestTableStats(t *testing.T) {
+	b := NewTableBuilder()
+	defer b.Close()
+	for i := 0; i < 100; i++ {
+		vs := y.ValueStruct{Value: []byte(fmt.Sprintf("%d", i))}
+		if i%10 == 0 {
+			vs.ExpiresAt = uint64(1000 + i)
+		}
+		require.NoError(t, b.Add(y.KeyWithTs([]byte(key("key", i)), 0), vs, i%4 == 0))
+	}
+	data := b.Finish()
+
+	writeTable := func(data []byte) *os.File {
+		filename := fmt.Sprintf("%s%s%d.sst", os.TempDir(), string(os.PathSeparator), rand.Int63())
+		f, err := y.OpenSyncedFile(filename, true)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		return f
+	}
+
+	tbl, err := OpenTable(writeTable(data), options.LoadToRAM)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+	stats := tbl.Stats()
+	require.Equal(t, Stats{
+		NumEntries:   100,
+		NumDeletes:   25,
+		NumExpiring:  10,
+		MinExpiresAt: 1000,
+		MaxExpiresAt: 1090,
+	}, stats)
+	require.Equal(t, 0.25, stats.GarbageRatio(500))
+	require.Equal(t, 0.3, stats.GarbageRatio(1045))
+	require.Equal(t, 0.35, stats.GarbageRatio(2000))
+
+	// The stats come right after the blocks, so readers going by the index don't see them.
+	statsEnd := bytes.Index(data, statsMagic) + len(statsMagic)
+	statsStart := statsEnd - (8*5 + 4 + len(statsMagic))
+	last := tbl.blockIndex[len(tbl.blockIndex)-1]
+	require.Equal(t, statsStart, last.offset+last.len)
+
+	// Tables written before stats were added have the index right after the blocks.
+	oldData := append(append([]byte{}, data[:statsStart]...), data[statsEnd:]...)
+	old, err := OpenTable(writeTable(oldData), options.LoadToRAM)
+	require.NoError(t, err)
+	defer old.DecrRef()
+	require.Equal(t, Stats{}, old.Stats())
+	it := old.NewIterator(false)
+	defer it.Close()
+	var count int
+	for it.Rewind(); it.Valid(); it.Next() {
+		count++
+	}
+	require.Equal(t, 100, count)
+}
+
+func TestTableInvalidIndex(t *testing.T) {
+	b := NewTableBuilder()
+	defer b.Close()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, b.Add(y.KeyWithTs([]byte(key("key", i)), 0), y.ValueStruct{}, false))
+	}
+	data := b.Finish()
+
+	// Zero out the number of blocks, stored right before the bloom filter.
+	bloomLen := int(binary.BigEndian.Uint32(data[len(data)-4:]))
+	pos := len(data) - 4 - bloomLen - 4
+	binary.BigEndian.PutUint32(data[pos:], 0)
+
+	filename := fmt.Sprintf("%s%s%d.sst", os.TempDir(), string(os.PathSeparator), rand.Int63())
+	f, err := y.OpenSyncedFile(filename, true)
+	require.NoError(t, err)
+	defer os.Remove(filename)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	_, err = OpenTable(f, options.LoadToRAM)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Invalid block index")
+}
+
+func TestTableEstimateRange(t *testing.T) {
+	f := buildTestTable(t, "key", 1050) // 11 blocks, the last one holding 50 keys.
+	tbl, err := OpenTable(f, options.LoadToRAM)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+
+	size, count := tbl.EstimateRange(nil, nil)
+	require.Equal(t, uint64(1050), count)
+	require.True(t, size > 0 && size < tbl.Size())
+
+	_, count = tbl.EstimateRange([]byte(key("key", 150)), []byte(key("key", 350)))
+	require.Equal(t, uint64(300), count) // Blocks 1, 2 and 3.
+	_, count = tbl.EstimateRange([]byte(key("key", 1020)), nil)
+	require.Equal(t, uint64(50), count)
+	_, count = tbl.EstimateRange([]byte("a"), []byte(key("key", 0)))
+	require.Equal(t, uint64(0), count)
+	_, count = tbl.EstimateRange([]byte("z"), nil)
+	require.Equal(t, uint64(0), count)
+
+	// Blocks which don't hold restartInterval entries each, as with many versions of a key, get an
+	// even share of the entries.
+	tbl.stats.NumEntries = 2200
+	_, count = tbl.EstimateRange(nil, nil)
+	require.Equal(t, uint64(2200), count)
+	_, count = tbl.EstimateRange([]byte(key("key", 150)), []byte(key("key", 350)))
+	require.Equal(t, uint64(600), count)
+}
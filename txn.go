@@ -19,7 +19,9 @@ package badger
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/hex"
+	"hash/crc32"
 	"math"
 	"sort"
 	"strconv"
@@ -51,15 +53,87 @@ type oracle struct {
 	discardTs uint64       // Used by ManagedDB.
 	readMark  *y.WaterMark // Used by DB.
 
+	// The following keep versions around for Options.VersionRetention, and for txns reading at
+	// past timestamps, whose read timestamps are counted in pastReads. discardedTs is the highest
+	// timestamp handed out to compactions for discarding versions. See retention.go.
+	retention   time.Duration
+	commitTimes []commitTime
+	pastReads   map[uint64]int
+	discardedTs uint64
+
 	// commits stores a key fingerprint and latest commit counter for it.
 	// refCount is used to clear out commits map to avoid a memory blowup.
 	commits map[uint64]uint64
+
+	// committedTxns stores the keys written by recent commits, to check the ranges read by
+	// iterators against. Fingerprints can't be used for that, but prefixes can. It is pruned as
+	// the transactions which could conflict with these commits finish.
+	committedTxns []committedTxn
+
+	// Update txns tracked for serializable snapshot isolation, while they're running, and once
+	// they've committed, for as long as running txns could depend on them. See ssiTxn.
+	ssiRunning   map[*ssiTxn]struct{}
+	ssiCommitted []*ssiTxn
+}
+
+type committedTxn struct {
+	ts       uint64
+	keys     [][]byte // Sorted. Only kept with Options.KeepConflictKeys, or for SSI.
+	prefixes []uint64 // Sorted prefixes of the keys, if the keys aren't kept. See keyPrefix.
+}
+
+// keyPrefix encodes the first 7 bytes of the key, padded with zeros, followed by its length, up to
+// 8. Unlike fingerprints, prefixes keep the order of the keys, so they can be checked against
+// ranges. Keys of up to 7 bytes are encoded exactly. Longer ones sharing their first 7 bytes get
+// the same prefix, so the check is conservative for them.
+func keyPrefix(key string) uint64 {
+	var buf [8]byte
+	copy(buf[:7], key)
+	if buf[7] = 8; len(key) < 8 {
+		buf[7] = byte(len(key))
+	}
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// readRange is a range of keys covered by an iterator, from start up to, but excluding, end. A nil
+// end means there's no upper bound.
+type readRange struct {
+	start, end []byte
+}
+
+// overlaps returns true if any of the sorted keys fall within r.
+func (r readRange) overlaps(keys [][]byte) bool {
+	idx := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], r.start) >= 0
+	})
+	return idx < len(keys) && (r.end == nil || bytes.Compare(keys[idx], r.end) < 0)
+}
+
+// overlapsPrefixes returns true if any of the keys with the sorted prefixes might fall within r.
+func (r readRange) overlapsPrefixes(prefixes []uint64) bool {
+	start := keyPrefix(string(r.start))
+	idx := sort.Search(len(prefixes), func(i int) bool {
+		return prefixes[i] >= start
+	})
+	if idx == len(prefixes) || r.end == nil {
+		return idx < len(prefixes)
+	}
+	// If the end is encoded exactly, so is a key with the same prefix, which is then the end.
+	end := keyPrefix(string(r.end))
+	return prefixes[idx] < end || (prefixes[idx] == end && len(r.end) > 7)
+}
+
+func (r readRange) contains(key []byte) bool {
+	return bytes.Compare(key, r.start) >= 0 && (r.end == nil || bytes.Compare(key, r.end) < 0)
 }
 
 func newOracle(opt Options) *oracle {
 	orc := &oracle{
-		isManaged: opt.managedTxns,
-		commits:   make(map[uint64]uint64),
+		isManaged:  opt.managedTxns,
+		commits:    make(map[uint64]uint64),
+		ssiRunning: make(map[*ssiTxn]struct{}),
+		retention:  opt.VersionRetention,
+		pastReads:  make(map[uint64]int),
 		// We're not initializing nextTxnTs and readOnlyTs. It would be done after replay in Open.
 		//
 		// WaterMarks must be 64-bit aligned for atomic package, hence we must use pointers here.
@@ -92,9 +166,18 @@ func (o *oracle) decrRef() {
 	if len(o.commits) >= 1000 { // If the map is still small, let it slide.
 		o.commits = make(map[uint64]uint64)
 	}
+	o.committedTxns = nil
+	o.ssiCommitted = nil
 }
 
 func (o *oracle) readTs() uint64 {
+	readTs, err := o.readTsCtx(context.Background())
+	y.Check(err)
+	return readTs
+}
+
+// readTsCtx is like readTs, but gives up waiting for pending commits once ctx is done.
+func (o *oracle) readTsCtx(ctx context.Context) (uint64, error) {
 	if o.isManaged {
 		panic("ReadTs should not be retrieved for managed DB")
 	}
@@ -109,8 +192,21 @@ func (o *oracle) readTs() uint64 {
 	// timestamp and are going through the write to value log and LSM tree
 	// process. Not waiting here could mean that some txns which have been
 	// committed would not be read.
-	y.Check(o.txnMark.WaitForMark(context.Background(), readTs))
-	return readTs
+	if err := o.txnMark.WaitForMark(ctx, readTs); err != nil {
+		o.readMark.Done(readTs)
+		return 0, err
+	}
+	return readTs, nil
+}
+
+// lastCommitTs returns the timestamp of the latest commit, once it is visible to reads.
+func (o *oracle) lastCommitTs() uint64 {
+	o.Lock()
+	ts := o.nextTxnTs - 1
+	o.Unlock()
+
+	y.Check(o.txnMark.WaitForMark(context.Background(), ts))
+	return ts
 }
 
 func (o *oracle) nextTs() uint64 {
@@ -128,35 +224,130 @@ func (o *oracle) setDiscardTs(ts uint64) {
 }
 
 func (o *oracle) discardAtOrBelow() uint64 {
+	o.Lock()
+	defer o.Unlock()
 	if o.isManaged {
-		o.Lock()
-		defer o.Unlock()
 		return o.discardTs
 	}
-	return o.readMark.DoneUntil()
+
+	ts := o.readMark.DoneUntil()
+	if o.retention > 0 {
+		if retained := o.retainedAbove(); retained < ts {
+			ts = retained
+		}
+	}
+	for readTs := range o.pastReads {
+		if readTs < ts {
+			ts = readTs
+		}
+	}
+	if ts > o.discardedTs {
+		o.discardedTs = ts
+	}
+	return ts
 }
 
-// hasConflict must be called while having a lock.
-func (o *oracle) hasConflict(txn *Txn) bool {
-	if len(txn.reads) == 0 {
-		return false
+// hasConflict must be called while having a lock. If the txn keeps the keys it read, it returns a
+// *ConflictError listing all the conflicting keys. Otherwise, it stops at the first conflict and
+// returns ErrConflict.
+func (o *oracle) hasConflict(txn *Txn) error {
+	if len(txn.reads) == 0 && len(txn.readRanges) == 0 && len(txn.lockReads) == 0 {
+		return nil
+	}
+	// Only used with Options.KeepConflictKeys, once there's a conflict.
+	var cerr *ConflictError
+	var seen map[string]struct{}
+	conflict := func(key []byte, ts uint64) {
+		if _, ok := seen[string(key)]; ok {
+			return
+		}
+		if cerr == nil {
+			cerr = &ConflictError{}
+			seen = make(map[string]struct{})
+		}
+		seen[string(key)] = struct{}{}
+		cerr.Keys = append(cerr.Keys, key)
+		if ts > cerr.CommitTs {
+			cerr.CommitTs = ts
+		}
 	}
 	for _, ro := range txn.reads {
 		// A commit at the read timestamp is expected.
 		// But, any commit after the read timestamp should cause a conflict.
 		if ts, has := o.commits[ro]; has && ts > txn.readTs {
-			return true
+			if txn.readKeys == nil {
+				return ErrConflict
+			}
+			conflict(txn.readKeys[ro], ts)
+		}
+	}
+	// The same goes for the keys read by GetForUpdate, at the later timestamp they were read at.
+	for _, lr := range txn.lockReads {
+		if ts, has := o.commits[lr.fp]; has && ts > lr.ts {
+			if txn.readKeys == nil {
+				return ErrConflict
+			}
+			conflict(txn.readKeys[lr.fp], ts)
+		}
+	}
+	// Keys written into the ranges covered by the txn's iterators conflict too, even if the txn
+	// never saw them. Otherwise, a txn acting on the results of a scan would miss new keys.
+	for _, ct := range o.committedTxns {
+		if ct.ts <= txn.readTs {
+			continue
+		}
+		for _, r := range txn.readRanges {
+			if ct.keys == nil {
+				if r.overlapsPrefixes(ct.prefixes) {
+					return ErrConflict
+				}
+				continue
+			}
+			if !r.overlaps(ct.keys) {
+				continue
+			}
+			if txn.readKeys == nil {
+				return ErrConflict
+			}
+			for _, key := range ct.keys {
+				if r.contains(key) {
+					conflict(key, ct.ts)
+				}
+			}
 		}
 	}
-	return false
+	if cerr == nil {
+		return nil
+	}
+	return cerr
 }
 
-func (o *oracle) newCommitTs(txn *Txn) uint64 {
+// newCommitTs returns the commit timestamp for the txn, or an error if it conflicts with another.
+func (o *oracle) newCommitTs(txn *Txn) (uint64, error) {
+	// Only copy the keys written if they're needed. Otherwise, their prefixes will do.
+	var keys [][]byte
+	var prefixes []uint64
+	if txn.db.opt.KeepConflictKeys || txn.ssi != nil {
+		keys = make([][]byte, 0, len(txn.pendingWrites))
+		txn.writtenKeys(func(k string) { keys = append(keys, []byte(k)) })
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+	} else {
+		prefixes = make([]uint64, 0, len(txn.pendingWrites))
+		txn.writtenKeys(func(k string) { prefixes = append(prefixes, keyPrefix(k)) })
+		sort.Slice(prefixes, func(i, j int) bool { return prefixes[i] < prefixes[j] })
+	}
+
 	o.Lock()
 	defer o.Unlock()
 
-	if o.hasConflict(txn) {
-		return 0
+	if txn.ssi != nil {
+		if err := o.ssiConflict(txn, keys); err != nil {
+			return 0, err
+		}
+	} else if err := o.hasConflict(txn); err != nil {
+		return 0, err
 	}
 
 	var ts uint64
@@ -165,6 +356,7 @@ func (o *oracle) newCommitTs(txn *Txn) uint64 {
 		ts = o.nextTxnTs
 		o.nextTxnTs++
 		o.txnMark.Begin(ts)
+		o.recordCommitTime(ts)
 
 	} else {
 		// If commitTs is set, use it instead.
@@ -174,7 +366,27 @@ func (o *oracle) newCommitTs(txn *Txn) uint64 {
 	for _, w := range txn.writes {
 		o.commits[w] = ts // Update the commitTs.
 	}
-	return ts
+
+	if !o.isManaged {
+		// Commits at or below the read watermark can't conflict with any running txn.
+		doneUntil := o.readMark.DoneUntil()
+		idx := sort.Search(len(o.committedTxns), func(i int) bool {
+			return o.committedTxns[i].ts > doneUntil
+		})
+		o.committedTxns = o.committedTxns[idx:]
+		idx = sort.Search(len(o.ssiCommitted), func(i int) bool {
+			return o.ssiCommitted[i].commitTs > doneUntil
+		})
+		o.ssiCommitted = o.ssiCommitted[idx:]
+	}
+	if len(keys) > 0 || len(prefixes) > 0 {
+		o.committedTxns = append(o.committedTxns,
+			committedTxn{ts: ts, keys: keys, prefixes: prefixes})
+	}
+	if txn.ssi != nil {
+		o.ssiCommit(txn.ssi, ts)
+	}
+	return ts, nil
 }
 
 func (o *oracle) doneCommit(cts uint64) {
@@ -190,14 +402,38 @@ type Txn struct {
 	readTs   uint64
 	commitTs uint64
 
-	update bool     // update is used to conditionally keep track of reads.
-	reads  []uint64 // contains fingerprints of keys read.
-	writes []uint64 // contains fingerprints of keys written.
+	update     bool        // update is used to conditionally keep track of reads.
+	reads      []uint64    // contains fingerprints of keys read.
+	readRanges []readRange // contains ranges of keys covered by iterators.
+	writes     []uint64    // contains fingerprints of keys written.
+
+	// readKeys maps fingerprints in reads back to the keys. Only kept with Options.KeepConflictKeys.
+	// readKeysAdded holds the fingerprints in the order they were added, for RollbackTo.
+	readKeys      map[uint64][]byte
+	readKeysAdded []uint64
+
+	// ssi tracks the txn with Options.SerializableSnapshotIsolation.
+	ssi *ssiTxn
+
+	// lockedKeys contains the keys locked via Lock. lockReads contains the keys read by
+	// GetForUpdate at a timestamp later than readTs, kept apart from reads.
+	lockedKeys map[string]struct{}
+	lockReads  []lockRead
+
+	// undo holds the entries overwritten in pendingWrites, so they can be restored by RollbackTo.
+	// It's only kept once a savepoint has been taken. savepoints holds the IDs of the savepoints
+	// which can still be rolled back to, in order.
+	undo            []undoEntry
+	savepoints      []int
+	nextSavepointID int
 
 	pendingWrites map[string]*Entry // cache stores any writes done by txn.
+	// spill holds the writes moved out of pendingWrites. Only used with Options.SpillLargeTxns.
+	spill *txnSpill
 
 	db        *DB
 	discarded bool
+	atVersion bool // Created by NewTransactionAtVersion.
 
 	size         int64
 	count        int64
@@ -220,9 +456,16 @@ func (pi *pendingWritesIterator) Rewind() {
 }
 
 func (pi *pendingWritesIterator) Seek(key []byte) {
-	key = y.ParseKey(key)
+	key, version := y.ParseKey(key), y.ParseTs(key)
 	pi.nextIdx = sort.Search(len(pi.entries), func(idx int) bool {
 		cmp := bytes.Compare(pi.entries[idx].Key, key)
+		if cmp == 0 && pi.readTs != version {
+			// All the entries are at readTs. Versions are sorted in descending order.
+			cmp = 1
+			if pi.readTs > version {
+				cmp = -1
+			}
+		}
 		if !pi.reversed {
 			return cmp >= 0
 		}
@@ -252,6 +495,18 @@ func (pi *pendingWritesIterator) Valid() bool {
 	return pi.nextIdx < len(pi.entries)
 }
 
+func (pi *pendingWritesIterator) SetReversed(reversed bool) {
+	if pi.reversed == reversed {
+		return
+	}
+	// The entries are sorted in the order of iteration.
+	for i, j := 0, len(pi.entries)-1; i < j; i, j = i+1, j-1 {
+		pi.entries[i], pi.entries[j] = pi.entries[j], pi.entries[i]
+	}
+	pi.reversed = reversed
+	pi.nextIdx = len(pi.entries)
+}
+
 func (pi *pendingWritesIterator) Close() error {
 	return nil
 }
@@ -279,17 +534,67 @@ func (txn *Txn) newPendingWritesIterator(reversed bool) *pendingWritesIterator {
 	}
 }
 
+// writtenKeys calls fn for every key written by the txn, including the spilled ones.
+func (txn *Txn) writtenKeys(fn func(key string)) {
+	for k := range txn.pendingWrites {
+		fn(k)
+	}
+	if txn.spill != nil {
+		for k := range txn.spill.ptrs {
+			if _, has := txn.pendingWrites[k]; !has {
+				fn(k)
+			}
+		}
+	}
+}
+
 func (txn *Txn) checkSize(e *Entry) error {
 	count := txn.count + 1
 	// Extra bytes for version in key.
 	size := txn.size + int64(e.estimateSize(txn.db.opt.ValueThreshold)) + 10
 	if count >= txn.db.opt.maxBatchCount || size >= txn.db.opt.maxBatchSize {
-		return ErrTxnTooBig
+		if !txn.db.opt.SpillLargeTxns {
+			return ErrTxnTooBig
+		}
+		if err := txn.spillPendingWrites(); err != nil {
+			return err
+		}
+		count = txn.count + 1
+		size = txn.size + int64(e.estimateSize(txn.db.opt.ValueThreshold)) + 10
 	}
 	txn.count, txn.size = count, size
 	return nil
 }
 
+// spillPendingWrites moves the pending writes into the spill file, making room for more. The
+// savepoints taken so far are invalidated.
+func (txn *Txn) spillPendingWrites() error {
+	if txn.spill == nil {
+		spill, err := newTxnSpill(txn.db.opt.ValueDir, atomic.AddUint64(&txn.db.lastSpillID, 1))
+		if err != nil {
+			return err
+		}
+		txn.spill = spill
+	}
+	// Leave room for the writes that remain in memory, so that the txn fits into a value log file.
+	if txn.spill.size+txn.db.opt.maxBatchSize >= txn.db.opt.ValueLogFileSize {
+		return ErrTxnTooBig
+	}
+	if txn.spill.lsmSize+txn.size > txn.db.opt.MaxSpilledTxnSize {
+		return ErrTxnTooBig
+	}
+	if err := txn.spill.write(txn.pendingWrites); err != nil {
+		return err
+	}
+	txn.spill.lsmSize += txn.size
+	txn.pendingWrites = make(map[string]*Entry)
+	txn.count = 1
+	txn.size = int64(len(txnKey) + 10)
+	txn.undo = nil
+	txn.savepoints = nil
+	return nil
+}
+
 // Set adds a key-value pair to the database.
 //
 // It will return ErrReadOnlyTxn if update flag was set to false when creating the
@@ -387,10 +692,102 @@ func (txn *Txn) modify(e *Entry) error {
 	}
 	fp := farm.Fingerprint64(e.Key) // Avoid dealing with byte arrays.
 	txn.writes = append(txn.writes, fp)
+	if len(txn.savepoints) > 0 {
+		prev := txn.pendingWrites[string(e.Key)]
+		txn.undo = append(txn.undo, undoEntry{key: string(e.Key), prev: prev})
+	}
 	txn.pendingWrites[string(e.Key)] = e
 	return nil
 }
 
+type undoEntry struct {
+	key  string
+	prev *Entry // Nil if the key wasn't written before.
+}
+
+// Savepoint marks a point in a transaction, which it can be rolled back to via Txn.RollbackTo.
+type Savepoint struct {
+	txn *Txn
+	id  int
+
+	undoLen, readsLen, readRangesLen, writesLen int
+	lockReadsLen, readKeysLen                   int
+	ssiReadsLen, ssiReadRangesLen               int
+	size, count                                 int64
+}
+
+// Savepoint returns a savepoint at the current state of the transaction. Rolling back to it via
+// RollbackTo undoes all the writes and reads done since, allowing a failed step of a multi-step
+// operation to be retried or skipped, without discarding the whole transaction.
+func (txn *Txn) Savepoint() *Savepoint {
+	sp := &Savepoint{
+		txn:           txn,
+		id:            txn.nextSavepointID,
+		undoLen:       len(txn.undo),
+		readsLen:      len(txn.reads),
+		readRangesLen: len(txn.readRanges),
+		writesLen:     len(txn.writes),
+		lockReadsLen:  len(txn.lockReads),
+		readKeysLen:   len(txn.readKeysAdded),
+		size:          txn.size,
+		count:         txn.count,
+	}
+	if txn.ssi != nil {
+		sp.ssiReadsLen, sp.ssiReadRangesLen = txn.ssi.numReads()
+	}
+	txn.nextSavepointID++
+	txn.savepoints = append(txn.savepoints, sp.id)
+	return sp
+}
+
+// RollbackTo restores the pending writes, and the keys tracked as read and written, to the state
+// they were in when the savepoint was taken. The savepoint stays valid, but the savepoints taken
+// after it are invalidated. Locks taken via Lock since are still held. With
+// Options.SerializableSnapshotIsolation, the dependencies recorded by the transactions which
+// committed in the meantime are kept, even if they came from the reads rolled back. Those can
+// still make the transaction fail to commit.
+//
+// It returns ErrInvalidSavepoint if the savepoint belongs to another transaction, or has been
+// invalidated. Spilling the writes of a large txn, see Options.SpillLargeTxns, invalidates all
+// the savepoints taken before.
+func (txn *Txn) RollbackTo(sp *Savepoint) error {
+	if txn.discarded {
+		return ErrDiscardedTxn
+	}
+	if sp == nil || sp.txn != txn {
+		return ErrInvalidSavepoint
+	}
+	idx := sort.SearchInts(txn.savepoints, sp.id)
+	if idx == len(txn.savepoints) || txn.savepoints[idx] != sp.id {
+		return ErrInvalidSavepoint
+	}
+	txn.savepoints = txn.savepoints[:idx+1]
+
+	for i := len(txn.undo) - 1; i >= sp.undoLen; i-- {
+		u := txn.undo[i]
+		if u.prev == nil {
+			delete(txn.pendingWrites, u.key)
+		} else {
+			txn.pendingWrites[u.key] = u.prev
+		}
+	}
+	txn.undo = txn.undo[:sp.undoLen]
+	txn.reads = txn.reads[:sp.readsLen]
+	txn.readRanges = txn.readRanges[:sp.readRangesLen]
+	txn.lockReads = txn.lockReads[:sp.lockReadsLen]
+	for _, fp := range txn.readKeysAdded[sp.readKeysLen:] {
+		delete(txn.readKeys, fp)
+	}
+	txn.readKeysAdded = txn.readKeysAdded[:sp.readKeysLen]
+	if txn.ssi != nil {
+		txn.ssi.rollback(sp.ssiReadsLen, sp.ssiReadRangesLen)
+	}
+	txn.writes = txn.writes[:sp.writesLen]
+	txn.size = sp.size
+	txn.count = sp.count
+	return nil
+}
+
 // SetEntry takes an Entry struct and adds the key-value pair in the struct,
 // along with other metadata to the database.
 //
@@ -419,39 +816,113 @@ func (txn *Txn) Delete(key []byte) error {
 // Get looks for key and returns corresponding Item.
 // If key is not found, ErrKeyNotFound is returned.
 func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
+	return txn.get(key, txn.readTs)
+}
+
+// GetForUpdate locks the key via Lock, and then looks it up like Get. Unlike Get, the latest
+// committed version of the key is returned, even if it was committed after the txn started. So,
+// as long as all the writers of a key use GetForUpdate, they queue up instead of conflicting.
+// Iterators and Get still read at the txn's read timestamp. With managed transactions, the key is
+// read at the read timestamp as well.
+func (txn *Txn) GetForUpdate(key []byte) (item *Item, rerr error) {
+	if err := txn.Lock(key); err != nil {
+		return nil, err
+	}
+	readTs := txn.readTs
+	if !txn.db.orc.isManaged {
+		readTs = txn.db.orc.lastCommitTs()
+	}
+	return txn.get(key, readTs)
+}
+
+// Lock takes an exclusive lock on the key, held until the txn is committed or discarded. If
+// another txn holds the lock, Lock blocks until it is released. It returns ErrDeadlock if waiting
+// would deadlock, and ErrLockTimeout if the lock isn't acquired within Options.LockTimeout.
+//
+// Locks are held within this process only, and don't affect txns which don't take them. Such txns
+// can still cause a locking txn to fail with ErrConflict, and vice versa.
+func (txn *Txn) Lock(key []byte) error {
+	switch {
+	case len(key) == 0:
+		return ErrEmptyKey
+	case txn.discarded:
+		return ErrDiscardedTxn
+	case !txn.update:
+		return ErrReadOnlyTxn
+	}
+	if _, ok := txn.lockedKeys[string(key)]; ok {
+		return nil // Already held.
+	}
+	if err := txn.db.locks.lock(txn, string(key), txn.db.opt.LockTimeout); err != nil {
+		return err
+	}
+	if txn.lockedKeys == nil {
+		txn.lockedKeys = make(map[string]struct{})
+	}
+	txn.lockedKeys[string(key)] = struct{}{}
+	return nil
+}
+
+func (txn *Txn) get(key []byte, readTs uint64) (item *Item, rerr error) {
 	if len(key) == 0 {
 		return nil, ErrEmptyKey
 	} else if txn.discarded {
 		return nil, ErrDiscardedTxn
 	}
 
-	item = new(Item)
 	if txn.update {
-		if e, has := txn.pendingWrites[string(key)]; has && bytes.Equal(key, e.Key) {
-			if isDeletedOrExpired(e.meta, e.ExpiresAt) {
-				return nil, ErrKeyNotFound
-			}
-			// Fulfill from cache.
-			item.meta = e.meta
-			item.val = e.Value
-			item.userMeta = e.UserMeta
-			item.key = key
-			item.status = prefetched
-			item.version = txn.readTs
-			item.expiresAt = e.ExpiresAt
-			// We probably don't need to set db on item here.
-			return item, nil
+		if item, err := txn.getPending(key); item != nil || err != nil {
+			return item, err
 		}
 		// Only track reads if this is update txn. No need to track read if txn serviced it
 		// internally.
-		txn.addReadKey(key)
+		if readTs > txn.readTs {
+			txn.addLockRead(key, readTs)
+		} else {
+			txn.addReadKey(key)
+		}
 	}
 
-	seek := y.KeyWithTs(key, txn.readTs)
+	seek := y.KeyWithTs(key, readTs)
 	vs, err := txn.db.get(seek)
 	if err != nil {
 		return nil, errors.Wrapf(err, "DB::Get key: %q", key)
 	}
+	return txn.newItem(key, vs)
+}
+
+// getPending looks up key in the pending writes of the txn. It returns a nil item, and no error,
+// if the txn hasn't written key.
+func (txn *Txn) getPending(key []byte) (*Item, error) {
+	e, has := txn.pendingWrites[string(key)]
+	if !has && txn.spill != nil {
+		spilled, err := txn.spill.get(key)
+		if err != nil {
+			return nil, err
+		}
+		e, has = spilled, spilled != nil
+	}
+	if !has || !bytes.Equal(key, e.Key) {
+		return nil, nil
+	}
+	if isDeletedOrExpired(e.meta, e.ExpiresAt) {
+		return nil, ErrKeyNotFound
+	}
+	// Fulfill from cache.
+	item := new(Item)
+	item.meta = e.meta
+	item.val = e.Value
+	item.userMeta = e.UserMeta
+	item.key = key
+	item.status = prefetched
+	item.version = txn.readTs
+	item.expiresAt = e.ExpiresAt
+	// We probably don't need to set db on item here.
+	return item, nil
+}
+
+// newItem returns the item for the value of key looked up in the DB, or ErrKeyNotFound.
+func (txn *Txn) newItem(key []byte, vs y.ValueStruct) (*Item, error) {
 	if vs.Value == nil && vs.Meta == 0 {
 		return nil, ErrKeyNotFound
 	}
@@ -459,6 +930,7 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 		return nil, ErrKeyNotFound
 	}
 
+	item := new(Item)
 	item.key = key
 	item.version = vs.Version
 	item.meta = vs.Meta
@@ -470,10 +942,170 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 	return item, nil
 }
 
+// KeyVersion is a single version of a key, as returned by Txn.History.
+type KeyVersion struct {
+	Version   uint64
+	Value     []byte // Nil for deletes.
+	UserMeta  byte
+	ExpiresAt uint64
+	Deleted   bool // Set if this version is a delete marker.
+}
+
+// History returns the committed versions of key visible to the txn, newest first, up to limit of
+// them. A limit of zero or less returns all the versions still on disk. Deleted and expired versions
+// are included, unlike with Get. Versions which have been discarded by compactions, as per
+// Options.NumVersionsToKeep, are gone. The pending writes of the txn aren't included.
+func (txn *Txn) History(key []byte, limit int) ([]KeyVersion, error) {
+	if len(key) == 0 {
+		return nil, ErrEmptyKey
+	} else if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+
+	opt := DefaultIteratorOptions
+	opt.PrefetchValues = false
+	opt.AllVersions = true
+	opt.committedOnly = true
+	// The iterator doesn't touch the pending writes, so it can run alongside one opened by the user.
+	opt.untracked = true
+	it := txn.NewKeyIterator(key, opt)
+	defer it.Close()
+
+	var history []KeyVersion
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		kv := KeyVersion{
+			Version:   item.Version(),
+			UserMeta:  item.UserMeta(),
+			ExpiresAt: item.ExpiresAt(),
+			Deleted:   item.meta&bitDelete > 0,
+		}
+		if !kv.Deleted {
+			var err error
+			if kv.Value, err = item.ValueCopy(nil); err != nil {
+				return nil, err
+			}
+		}
+		history = append(history, kv)
+		// Earlier versions are considered gone, even while they're still on disk.
+		if item.DiscardEarlierVersions() || len(history) == limit {
+			break
+		}
+	}
+	return history, nil
+}
+
+// GetMany looks up multiple keys at once, which is faster than calling Get for each of them. The
+// keys are looked up in sorted order, going through the LSM tree once for the whole batch, and the
+// values stored in the value log are read in batches per file, instead of on Item.Value.
+//
+// It returns the items in the order of the keys, with errs[i] set to ErrKeyNotFound if keys[i]
+// isn't found, or to any other error encountered while looking it up.
+func (txn *Txn) GetMany(keys [][]byte) (items []*Item, errs []error) {
+	items, errs = make([]*Item, len(keys)), make([]error, len(keys))
+	var lookup []int // Indices of the keys to look up in the DB.
+	for i, key := range keys {
+		switch {
+		case len(key) == 0:
+			errs[i] = ErrEmptyKey
+			continue
+		case txn.discarded:
+			errs[i] = ErrDiscardedTxn
+			continue
+		}
+		if txn.update {
+			if items[i], errs[i] = txn.getPending(key); items[i] != nil || errs[i] != nil {
+				continue
+			}
+			txn.addReadKey(key)
+		}
+		lookup = append(lookup, i)
+	}
+	if len(lookup) == 0 {
+		return items, errs
+	}
+
+	sort.Slice(lookup, func(i, j int) bool {
+		return bytes.Compare(keys[lookup[i]], keys[lookup[j]]) < 0
+	})
+	seeks := make([][]byte, len(lookup))
+	for j, i := range lookup {
+		seeks[j] = y.KeyWithTs(keys[i], txn.readTs)
+	}
+	vs, err := txn.db.getMany(seeks)
+	if err != nil {
+		err = errors.Wrapf(err, "DB::GetMany %d keys", len(lookup))
+		for _, i := range lookup {
+			errs[i] = err
+		}
+		return items, errs
+	}
+
+	var vps []valuePointer
+	var vpItems []int
+	for j, i := range lookup {
+		items[i], errs[i] = txn.newItem(keys[i], vs[j])
+		if items[i] != nil && items[i].meta&bitValuePointer > 0 {
+			var vp valuePointer
+			vp.Decode(items[i].vptr)
+			vps = append(vps, vp)
+			vpItems = append(vpItems, i)
+		}
+	}
+	vals, err := txn.db.vlog.readMany(vps)
+	if err != nil {
+		for _, i := range vpItems {
+			items[i], errs[i] = nil, err
+		}
+		return items, errs
+	}
+	for k, i := range vpItems {
+		if vals[k] != nil {
+			items[i].val = vals[k]
+			items[i].status = prefetched
+		}
+	}
+	return items, errs
+}
+
 func (txn *Txn) addReadKey(key []byte) {
 	if txn.update {
 		fp := farm.Fingerprint64(key)
 		txn.reads = append(txn.reads, fp)
+		txn.trackRead(fp, key)
+	}
+}
+
+// lockRead is a key read by GetForUpdate, at a timestamp later than the txn's read timestamp.
+type lockRead struct {
+	fp, ts uint64
+}
+
+func (txn *Txn) addLockRead(key []byte, ts uint64) {
+	fp := farm.Fingerprint64(key)
+	txn.lockReads = append(txn.lockReads, lockRead{fp: fp, ts: ts})
+	txn.trackRead(fp, key)
+}
+
+// trackRead records the key read for SSI, and for conflict errors.
+func (txn *Txn) trackRead(fp uint64, key []byte) {
+	if txn.ssi != nil {
+		txn.ssi.addRead(fp)
+	}
+	if txn.readKeys != nil {
+		if _, ok := txn.readKeys[fp]; !ok {
+			txn.readKeys[fp] = y.SafeCopy(nil, key)
+			txn.readKeysAdded = append(txn.readKeysAdded, fp)
+		}
+	}
+}
+
+func (txn *Txn) addReadRange(start, end []byte) {
+	if txn.update {
+		txn.readRanges = append(txn.readRanges, readRange{start: start, end: end})
+		if txn.ssi != nil {
+			txn.ssi.addReadRange(readRange{start: start, end: end})
+		}
 	}
 }
 
@@ -486,11 +1118,35 @@ func (txn *Txn) Discard() {
 	if txn.discarded { // Avoid a re-run.
 		return
 	}
+	txn.setDiscarded()
+	txn.release()
+}
+
+func (txn *Txn) setDiscarded() {
 	if atomic.LoadInt32(&txn.numIterators) > 0 {
 		panic("Unclosed iterator at time of Txn.Discard.")
 	}
 	txn.discarded = true
-	if !txn.db.orc.isManaged {
+}
+
+// release lets go of the spill file, key locks and read timestamp held by the txn.
+func (txn *Txn) release() {
+	if txn.spill != nil {
+		if err := txn.spill.delete(); err != nil {
+			Warningf("Unable to delete spill file: %v", err)
+		}
+		txn.spill = nil
+	}
+	if len(txn.lockedKeys) > 0 {
+		txn.db.locks.unlock(txn, txn.lockedKeys)
+		txn.lockedKeys = nil
+	}
+	if txn.ssi != nil && txn.ssi.commitTs == 0 {
+		txn.db.orc.removeSSITxn(txn.ssi)
+	}
+	if txn.atVersion {
+		txn.db.orc.unpinRead(txn.readTs)
+	} else if !txn.db.orc.isManaged {
 		txn.db.orc.readMark.Done(txn.readTs)
 	}
 	if txn.update {
@@ -498,7 +1154,7 @@ func (txn *Txn) Discard() {
 	}
 }
 
-func (txn *Txn) commitAndSend() (func() error, error) {
+func (txn *Txn) commitAndSend(ctx context.Context) (func() error, error) {
 	orc := txn.db.orc
 	// Ensure that the order in which we get the commit timestamp is the same as
 	// the order in which we push these updates to the write channel. So, we
@@ -507,9 +1163,18 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 	orc.writeChLock.Lock()
 	defer orc.writeChLock.Unlock()
 
-	commitTs := orc.newCommitTs(txn)
-	if commitTs == 0 {
-		return nil, ErrConflict
+	commitTs, err := orc.newCommitTs(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	if txn.spill != nil {
+		if err := txn.sendSpilled(ctx, commitTs); err != nil {
+			orc.doneCommit(commitTs)
+			return nil, err
+		}
+		// The rest of the txn has to follow the part sent.
+		ctx = context.Background()
 	}
 
 	// The following debug information is what led to determining the cause of
@@ -536,7 +1201,7 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 	}
 	entries = append(entries, e)
 
-	req, err := txn.db.sendToWriteCh(entries)
+	req, err := txn.db.sendToWriteCh(ctx, entries)
 	if err != nil {
 		orc.doneCommit(commitTs)
 		return nil, err
@@ -552,6 +1217,82 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 	return ret, nil
 }
 
+// sendSpilled sends the spilled writes of the txn at commitTs, streaming them from the spill file
+// in as many requests as it takes to keep each within the write batch limits. These requests
+// leave the txn open, for the one with the writes held in memory to close. See
+// DB.writeRequests. Once a request has been sent, ctx is no longer checked, as the rest of the
+// txn has to follow it. If sendSpilled fails midway, the txn is abandoned, and closed in the value
+// log by an entry with bitAbortTxn set.
+func (txn *Txn) sendSpilled(ctx context.Context, commitTs uint64) error {
+	db := txn.db
+	runs := txn.spill.sortedRuns(txn.pendingWrites)
+
+	// The whole txn has to go into a single value log file. Its size lets the value log switch
+	// files up front if needed. See valueLog.write.
+	txnSize := int64(headerBufSize + len(txnKey) + 8 + len(strconv.FormatUint(commitTs, 10)) +
+		crc32.Size)
+	for _, run := range runs {
+		for _, ref := range run {
+			txnSize += int64(ref.ptr.len) + 8 // Plus the commit ts appended to the key.
+		}
+	}
+	for _, e := range txn.pendingWrites {
+		txnSize += int64(headerBufSize + len(e.Key) + 8 + len(e.Value) + crc32.Size)
+	}
+
+	var entries []*Entry
+	var size int64
+	var sent bool
+	send := func() error {
+		req, err := db.sendTxnPartToWriteCh(ctx, entries, txnSize)
+		if err != nil {
+			return err
+		}
+		ctx = context.Background()
+		entries, size, txnSize, sent = nil, 0, 0, true
+		return req.Wait()
+	}
+	abort := func(err error) error {
+		if err == nil || !sent {
+			return err
+		}
+		req, aerr := db.sendToWriteCh(ctx, []*Entry{{
+			Key:  y.KeyWithTs(txnKey, commitTs),
+			meta: bitFinTxn | bitAbortTxn,
+		}})
+		if aerr == nil {
+			aerr = req.Wait()
+		}
+		if aerr != nil {
+			Warningf("Unable to abort txn at timestamp %d: %v", commitTs, aerr)
+		}
+		return err
+	}
+	for _, run := range runs {
+		reader := spillReader{spill: txn.spill}
+		for _, ref := range run {
+			e, err := reader.read(ref.ptr, false)
+			if err != nil {
+				return abort(err)
+			}
+			e.Key = y.KeyWithTs(e.Key, commitTs)
+			e.meta |= bitTxn
+			esize := int64(e.estimateSize(db.opt.ValueThreshold))
+			if int64(len(entries))+1 >= db.opt.maxBatchCount || size+esize >= db.opt.maxBatchSize {
+				if err := send(); err != nil {
+					return abort(err)
+				}
+			}
+			entries = append(entries, &e)
+			size += esize
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return abort(send())
+}
+
 func (txn *Txn) commitPrecheck() {
 	if txn.commitTs == 0 && txn.db.opt.managedTxns {
 		panic("Commit cannot be called with managedDB=true. Use CommitAt.")
@@ -587,7 +1328,7 @@ func (txn *Txn) Commit() error {
 		return nil // Nothing to do.
 	}
 
-	txnCb, err := txn.commitAndSend()
+	txnCb, err := txn.commitAndSend(context.Background())
 	if err != nil {
 		return err
 	}
@@ -598,6 +1339,43 @@ func (txn *Txn) Commit() error {
 	return txnCb()
 }
 
+// CommitCtx acts like Commit, but stops waiting once ctx is done. If the writes haven't been handed
+// off yet, it returns ctx.Err(), and the txn isn't committed. Otherwise, e.g. while writes are
+// stalled on level 0 compactions, it returns ErrCommitPending, as the writes can't be recalled.
+// The txn keeps holding its key locks and read timestamp until the writes are applied, or fail.
+func (txn *Txn) CommitCtx(ctx context.Context) error {
+	txn.commitPrecheck() // Precheck before discarding txn.
+
+	if len(txn.writes) == 0 {
+		txn.Discard()
+		return nil // Nothing to do.
+	}
+	if err := ctx.Err(); err != nil {
+		txn.Discard()
+		return err
+	}
+
+	txnCb, err := txn.commitAndSend(ctx)
+	if err != nil {
+		txn.Discard()
+		return err
+	}
+	// Discard is a no-op from here on. The txn is released once the writes are done.
+	txn.setDiscarded()
+	errCh := make(chan error, 1)
+	go func() {
+		err := txnCb()
+		txn.release()
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ErrCommitPending
+	}
+}
+
 type txnCb struct {
 	commit func() error
 	user   func(error)
@@ -631,6 +1409,16 @@ func (txn *Txn) CommitWith(cb func(error)) {
 	if cb == nil {
 		panic("Nil callback provided to CommitWith")
 	}
+	if locked := txn.lockedKeys; len(locked) > 0 {
+		// Hold on to the locks until the writes are visible, so the next txn to take them gets
+		// to read the writes.
+		txn.lockedKeys = nil
+		userCb := cb
+		cb = func(err error) {
+			txn.db.locks.unlock(txn, locked)
+			userCb(err)
+		}
+	}
 
 	if len(txn.writes) == 0 {
 		// Do not run these callbacks from here, because the CommitWith and the
@@ -640,7 +1428,7 @@ func (txn *Txn) CommitWith(cb func(error)) {
 		return
 	}
 
-	commitCb, err := txn.commitAndSend()
+	commitCb, err := txn.commitAndSend(context.Background())
 	if err != nil {
 		go runTxnCallback(&txnCb{user: cb, err: err})
 		return
@@ -679,6 +1467,14 @@ func (db *DB) NewTransaction(update bool) *Txn {
 }
 
 func (db *DB) newTransaction(update, isManaged bool) *Txn {
+	txn, err := db.newTransactionCtx(context.Background(), update, isManaged)
+	y.Check(err)
+	return txn
+}
+
+// newTransactionCtx is like newTransaction, but gives up waiting for the read timestamp once ctx
+// is done.
+func (db *DB) newTransactionCtx(ctx context.Context, update, isManaged bool) (*Txn, error) {
 	if db.opt.ReadOnly && update {
 		// DB is read-only, force read-only transaction.
 		update = false
@@ -692,6 +1488,9 @@ func (db *DB) newTransaction(update, isManaged bool) *Txn {
 	}
 	if update {
 		txn.pendingWrites = make(map[string]*Entry)
+		if db.opt.KeepConflictKeys {
+			txn.readKeys = make(map[uint64][]byte)
+		}
 		txn.db.orc.addRef()
 	}
 	// It is important that the oracle addRef happens BEFORE we retrieve a read
@@ -707,9 +1506,20 @@ func (db *DB) newTransaction(update, isManaged bool) *Txn {
 	//    would be detected.
 	// See issue: https://github.com/dgraph-io/badger/issues/574
 	if !isManaged {
-		txn.readTs = db.orc.readTs()
+		readTs, err := db.orc.readTsCtx(ctx)
+		if err != nil {
+			if update {
+				db.orc.decrRef()
+			}
+			return nil, err
+		}
+		txn.readTs = readTs
 	}
-	return txn
+	if update && db.opt.SerializableSnapshotIsolation {
+		txn.ssi = new(ssiTxn)
+		db.orc.addSSITxn(txn.ssi)
+	}
+	return txn, nil
 }
 
 // View executes a function creating and managing a read-only transaction for the user. Error
@@ -743,3 +1553,39 @@ func (db *DB) Update(fn func(txn *Txn) error) error {
 
 	return txn.Commit()
 }
+
+// ViewCtx acts like View, but gives up once ctx is done while waiting for pending commits to be
+// applied before the transaction starts, returning ctx.Err().
+func (db *DB) ViewCtx(ctx context.Context, fn func(txn *Txn) error) error {
+	var txn *Txn
+	if db.opt.managedTxns {
+		txn = db.NewTransactionAt(math.MaxUint64, false)
+	} else {
+		var err error
+		if txn, err = db.newTransactionCtx(ctx, false, false); err != nil {
+			return err
+		}
+	}
+	defer txn.Discard()
+
+	return fn(txn)
+}
+
+// UpdateCtx acts like Update, but gives up once ctx is done while waiting for pending commits
+// before the transaction starts, or while committing it. See Txn.CommitCtx.
+func (db *DB) UpdateCtx(ctx context.Context, fn func(txn *Txn) error) error {
+	if db.opt.managedTxns {
+		panic("UpdateCtx can only be used with managedDB=false.")
+	}
+	txn, err := db.newTransactionCtx(ctx, true, false)
+	if err != nil {
+		return err
+	}
+	defer txn.Discard()
+
+	if err := fn(txn); err != nil {
+		return err
+	}
+
+	return txn.CommitCtx(ctx)
+}
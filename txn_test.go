@@ -17,17 +17,22 @@
 package badger
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/dgraph-io/badger/options"
 	"github.com/dgraph-io/badger/y"
+	"github.com/pkg/errors"
 
 	"github.com/stretchr/testify/require"
 )
@@ -316,6 +321,880 @@ func TestTxnWriteSkew(t *testing.T) {
 	})
 }
 
+func TestTxnRangeConflict(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		set := func(key string) error {
+			txn := db.NewTransaction(true)
+			defer txn.Discard()
+			require.NoError(t, txn.Set([]byte(key), []byte("v")))
+			return txn.Commit()
+		}
+		require.NoError(t, set("k1"))
+		require.NoError(t, set("k3"))
+
+		// scan counts the keys from seek onwards, stopping at the key limit, and writes the
+		// count. It only commits once the key passed to write has been set by another txn.
+		scan := func(opt IteratorOptions, seek, limit, write string) error {
+			txn := db.NewTransaction(true)
+			defer txn.Discard()
+			it := txn.NewIterator(opt)
+			var count int
+			for it.Seek([]byte(seek)); it.Valid(); it.Next() {
+				if limit != "" && string(it.Item().Key()) >= limit {
+					break
+				}
+				count++
+			}
+			it.Close()
+			require.NoError(t, txn.Set([]byte("count"), []byte(strconv.Itoa(count))))
+			require.NoError(t, set(write))
+			return txn.Commit()
+		}
+
+		// A key inserted into the scanned range is a conflict.
+		require.Equal(t, ErrConflict, scan(DefaultIteratorOptions, "k", "", "k2"))
+		// So is a key inserted after the last key, if the scan ran to the end.
+		require.Equal(t, ErrConflict, scan(DefaultIteratorOptions, "k", "", "z"))
+		// Keys before the start, or after where the scan stopped, aren't.
+		require.NoError(t, scan(DefaultIteratorOptions, "k2", "k3", "k0"))
+		require.NoError(t, scan(DefaultIteratorOptions, "k2", "k3", "k4"))
+		// The key the scan stopped at was read.
+		require.Equal(t, ErrConflict, scan(DefaultIteratorOptions, "k2", "k3", "k3"))
+
+		// With a prefix, the range ends with the prefix.
+		opt := DefaultIteratorOptions
+		opt.Prefix = []byte("k")
+		require.NoError(t, scan(opt, "", "", "l"))
+		require.Equal(t, ErrConflict, scan(opt, "", "", "k9"))
+
+		// For reverse iteration, the range goes from the seek key downwards.
+		opt = DefaultIteratorOptions
+		opt.Reverse = true
+		require.NoError(t, scan(opt, "k25", "", "k26"))
+		require.Equal(t, ErrConflict, scan(opt, "k25", "", "k24"))
+		require.Equal(t, ErrConflict, scan(opt, "k25", "", "a"))
+	})
+}
+
+func TestReadRangeOverlapsPrefixes(t *testing.T) {
+	prefixes := func(keys ...string) []uint64 {
+		var ps []uint64
+		for _, k := range keys {
+			ps = append(ps, keyPrefix(k))
+		}
+		return ps
+	}
+	r := readRange{start: []byte("k2"), end: []byte("k4")}
+	require.True(t, r.overlapsPrefixes(prefixes("a", "k3", "z")))
+	require.True(t, r.overlapsPrefixes(prefixes("k2")))
+	require.False(t, r.overlapsPrefixes(prefixes("a", "k1", "k5", "z")))
+	require.False(t, r.overlapsPrefixes(nil))
+	require.True(t, readRange{start: []byte("k2")}.overlapsPrefixes(prefixes("z")))
+
+	require.False(t, readRange{start: []byte("k"), end: []byte("l")}.overlapsPrefixes(prefixes("l")))
+	require.True(t, readRange{start: []byte("k"), end: []byte("l")}.overlapsPrefixes(prefixes("k\xff")))
+
+	// Long keys sharing the first 7 bytes with a bound can't be told apart, so they might conflict.
+	r = readRange{start: []byte("prefix-b"), end: []byte("prefix-d")}
+	require.True(t, r.overlapsPrefixes(prefixes("prefix-a")))
+	require.True(t, r.overlapsPrefixes(prefixes("prefix-e")))
+	require.False(t, r.overlapsPrefixes(prefixes("prefiy-a", "prefix")))
+}
+
+func TestTxnConflictKeys(t *testing.T) {
+	opt := getTestOptions("")
+	opt.KeepConflictKeys = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		set := func(key string) {
+			txn := db.NewTransaction(true)
+			defer txn.Discard()
+			require.NoError(t, txn.Set([]byte(key), []byte("v")))
+			require.NoError(t, txn.Commit())
+		}
+		set("a")
+
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		_, err := txn.Get([]byte("a"))
+		require.NoError(t, err)
+		_, err = txn.Get([]byte("b"))
+		require.Equal(t, ErrKeyNotFound, err)
+		it := txn.NewIterator(DefaultIteratorOptions)
+		for it.Seek([]byte("k")); it.Valid(); it.Next() {
+		}
+		it.Close()
+		require.NoError(t, txn.Set([]byte("c"), []byte("v")))
+
+		set("b")
+		set("k1")
+		set("c0") // Not read.
+		set("a")
+		set("z") // Within the range iterated over.
+
+		err = txn.Commit()
+		cerr, ok := err.(*ConflictError)
+		require.True(t, ok)
+		var keys []string
+		for _, k := range cerr.Keys {
+			keys = append(keys, string(k))
+		}
+		require.ElementsMatch(t, []string{"a", "b", "k1", "z"}, keys)
+		require.Equal(t, db.orc.readTs(), cerr.CommitTs)
+	})
+}
+
+func TestTxnGetForUpdate(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("counter")
+		incr := func() error {
+			txn := db.NewTransaction(true)
+			defer txn.Discard()
+			var count int
+			item, err := txn.GetForUpdate(key)
+			if err == nil {
+				val, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				count, err = strconv.Atoi(string(val))
+				require.NoError(t, err)
+			} else if err != ErrKeyNotFound {
+				return err
+			}
+			if err := txn.Set(key, []byte(strconv.Itoa(count+1))); err != nil {
+				return err
+			}
+			return txn.Commit()
+		}
+
+		// Without locking, these would mostly fail with ErrConflict.
+		errCh := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			go func() {
+				var err error
+				for j := 0; j < 50 && err == nil; j++ {
+					err = incr()
+				}
+				errCh <- err
+			}()
+		}
+		for i := 0; i < 8; i++ {
+			require.NoError(t, <-errCh)
+		}
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get(key)
+			require.NoError(t, err)
+			val, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, "400", string(val))
+			return nil
+		}))
+
+		// A key read by Get before a concurrent write still conflicts, even if it's read again by
+		// GetForUpdate after that write.
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		_, err := txn.Get(key)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("0"))
+		}))
+		_, err = txn.GetForUpdate(key)
+		require.NoError(t, err)
+		require.NoError(t, txn.Set([]byte("other"), []byte("v")))
+		require.Equal(t, ErrConflict, txn.Commit())
+
+		// Without the read by Get, there's no conflict.
+		txn = db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key, []byte("1"))
+		}))
+		_, err = txn.GetForUpdate(key)
+		require.NoError(t, err)
+		require.NoError(t, txn.Set([]byte("other"), []byte("v")))
+		require.NoError(t, txn.Commit())
+	})
+}
+
+func TestTxnLockDeadlock(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txn1 := db.NewTransaction(true)
+		defer txn1.Discard()
+		txn2 := db.NewTransaction(true)
+		defer txn2.Discard()
+		require.NoError(t, txn1.Lock([]byte("a")))
+		require.NoError(t, txn2.Lock([]byte("b")))
+
+		locked := make(chan error)
+		go func() {
+			locked <- txn2.Lock([]byte("a"))
+		}()
+		// Wait for txn2 to wait on txn1.
+		for {
+			db.locks.Lock()
+			waiting := db.locks.waitsFor[txn2] == txn1
+			db.locks.Unlock()
+			if waiting {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		require.Equal(t, ErrDeadlock, txn1.Lock([]byte("b")))
+
+		txn1.Discard()
+		require.NoError(t, <-locked)
+	})
+}
+
+func TestTxnLockTimeout(t *testing.T) {
+	opt := getTestOptions("")
+	opt.LockTimeout = 20 * time.Millisecond
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txn1 := db.NewTransaction(true)
+		defer txn1.Discard()
+		require.NoError(t, txn1.Lock([]byte("a")))
+		require.NoError(t, txn1.Lock([]byte("a"))) // Locks are reentrant.
+
+		txn2 := db.NewTransaction(true)
+		defer txn2.Discard()
+		require.Equal(t, ErrLockTimeout, txn2.Lock([]byte("a")))
+		require.NoError(t, txn2.Lock([]byte("b")))
+
+		readTxn := db.NewTransaction(false)
+		defer readTxn.Discard()
+		require.Equal(t, ErrReadOnlyTxn, readTxn.Lock([]byte("c")))
+
+		require.NoError(t, txn1.Commit())
+		require.NoError(t, txn2.Lock([]byte("a")))
+	})
+}
+
+func TestTxnSavepoint(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		getVal := func(key string) string {
+			item, err := txn.Get([]byte(key))
+			if err == ErrKeyNotFound {
+				return ""
+			}
+			require.NoError(t, err)
+			val, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			return string(val)
+		}
+
+		require.NoError(t, txn.Set([]byte("a"), []byte("1")))
+		sp1 := txn.Savepoint()
+		require.NoError(t, txn.Set([]byte("a"), []byte("2")))
+		require.NoError(t, txn.Set([]byte("b"), []byte("2")))
+		sp2 := txn.Savepoint()
+		require.NoError(t, txn.Delete([]byte("a")))
+		require.Equal(t, "", getVal("a"))
+
+		require.NoError(t, txn.RollbackTo(sp2))
+		require.Equal(t, "2", getVal("a"))
+		require.Equal(t, "2", getVal("b"))
+
+		require.NoError(t, txn.RollbackTo(sp1))
+		require.Equal(t, "1", getVal("a"))
+		require.Equal(t, "", getVal("b"))
+		require.Len(t, txn.writes, 1)
+		require.Equal(t, int64(2), txn.count)
+
+		// Rolling back to sp1 invalidated sp2, but not sp1 itself.
+		require.Equal(t, ErrInvalidSavepoint, txn.RollbackTo(sp2))
+		require.NoError(t, txn.Set([]byte("c"), []byte("3")))
+		require.NoError(t, txn.RollbackTo(sp1))
+
+		other := db.NewTransaction(true)
+		defer other.Discard()
+		require.Equal(t, ErrInvalidSavepoint, other.RollbackTo(sp1))
+
+		require.NoError(t, txn.Commit())
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("a"))
+			require.NoError(t, err)
+			val, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, "1", string(val))
+			for _, key := range []string{"b", "c"} {
+				_, err := txn.Get([]byte(key))
+				require.Equal(t, ErrKeyNotFound, err)
+			}
+			return nil
+		}))
+	})
+}
+
+func TestTxnSavepointReads(t *testing.T) {
+	opt := getTestOptions("")
+	opt.SerializableSnapshotIsolation = true
+	opt.KeepConflictKeys = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		get := func(txn *Txn, key string) {
+			_, err := txn.Get([]byte(key))
+			require.Equal(t, ErrKeyNotFound, err)
+		}
+
+		// Write skew, except that txn2 rolls back its read of x. So, txn2 merely gets serialized
+		// after txn1.
+		txn1 := db.NewTransaction(true)
+		txn2 := db.NewTransaction(true)
+		get(txn1, "x")
+		get(txn1, "y")
+		require.NoError(t, txn1.Set([]byte("x"), []byte("v")))
+		get(txn2, "y")
+		sp := txn2.Savepoint()
+		get(txn2, "x")
+		require.NoError(t, txn2.RollbackTo(sp))
+		require.NoError(t, txn1.Commit())
+		require.Len(t, txn2.readKeys, 1)
+		require.NoError(t, txn2.Set([]byte("y"), []byte("v")))
+		require.NoError(t, txn2.Commit())
+	})
+}
+
+func TestTxnSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	opt := getTestOptions(dir)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	txn := db.NewTransaction(true)
+	var i int
+	for ; err == nil; i++ {
+		err = txn.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("val"))
+	}
+	require.Equal(t, ErrTxnTooBig, err)
+	txn.Discard()
+	require.NoError(t, db.Close())
+
+	opt.SpillLargeTxns = true
+	db, err = Open(opt)
+	require.NoError(t, err)
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	val := func(i int) []byte { return []byte(fmt.Sprintf("%0100d", i)) }
+	check := func(txn *Txn, n int) {
+		for i := 0; i < n; i++ {
+			item, err := txn.Get(key(i))
+			require.NoError(t, err)
+			v, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, val(i), v)
+		}
+		it := txn.NewIterator(DefaultIteratorOptions)
+		defer it.Close()
+		var count int
+		for it.Rewind(); it.Valid(); it.Next() {
+			require.Equal(t, key(count), it.Item().Key())
+			v, err := it.Item().ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, val(count), v)
+			count++
+		}
+		require.Equal(t, n, count)
+		require.NoError(t, it.Err())
+		it.Close()
+
+		opt := DefaultIteratorOptions
+		opt.Reverse = true
+		it = txn.NewIterator(opt)
+		defer it.Close()
+		i := n / 2
+		for it.Seek(key(i)); it.Valid(); it.Next() {
+			require.Equal(t, key(i), it.Item().Key())
+			i--
+		}
+		require.Equal(t, -1, i)
+	}
+
+	// The txn is a lot bigger than a memtable, let alone a write batch.
+	n := 20 * i
+	txn = db.NewTransaction(true)
+	for i := 0; i < n; i++ {
+		require.NoError(t, txn.Set(key(i), []byte("old")))
+	}
+	for i := 0; i < n; i++ {
+		require.NoError(t, txn.Set(key(i), val(i)))
+	}
+	require.NotNil(t, txn.spill)
+	check(txn, n)
+	require.NoError(t, txn.Commit())
+
+	spills, err := filepath.Glob(filepath.Join(dir, "*.spill"))
+	require.NoError(t, err)
+	require.Empty(t, spills)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		check(txn, n)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	// The txn survives a replay of the value log.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.View(func(txn *Txn) error {
+		check(txn, n)
+		return nil
+	}))
+}
+
+func TestTxnSpillReadError(t *testing.T) {
+	opt := getTestOptions("")
+	opt.SpillLargeTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		for i := 0; txn.spill == nil; i++ {
+			require.NoError(t, txn.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("val")))
+		}
+		require.NoError(t, txn.spill.fd.Close())
+
+		it := txn.NewIterator(DefaultIteratorOptions)
+		it.Rewind()
+		require.False(t, it.Valid())
+		require.Error(t, it.Err())
+		it.Close()
+
+		require.Error(t, txn.Commit())
+	})
+}
+
+func TestTxnSpillTooBig(t *testing.T) {
+	opt := getTestOptions("")
+	opt.SpillLargeTxns = true
+	opt.MaxSpilledTxnSize = opt.MaxTableSize / 2
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		var err error
+		for i := 0; err == nil; i++ {
+			err = txn.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("val"))
+		}
+		require.Equal(t, ErrTxnTooBig, err)
+		require.True(t, txn.spill.lsmSize <= opt.MaxSpilledTxnSize)
+		require.True(t, txn.spill.lsmSize > db.opt.maxBatchSize)
+	})
+}
+
+func TestTxnSpillValueLogFile(t *testing.T) {
+	opt := getTestOptions("")
+	opt.SpillLargeTxns = true
+	opt.ValueLogFileSize = 1 << 20
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		val := make([]byte, 1000)
+		// Fill most of the first value log file.
+		batch := db.NewWriteBatch()
+		for i := 0; i < 700; i++ {
+			require.NoError(t, batch.Set([]byte(fmt.Sprintf("fill%05d", i)), val, 0))
+		}
+		require.NoError(t, batch.Flush())
+		fid := atomic.LoadUint32(&db.vlog.maxFid)
+
+		// The txn doesn't fit into what's left of the file, so it goes into the next one.
+		key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+		n := 600
+		txn := db.NewTransaction(true)
+		for i := 0; i < n; i++ {
+			require.NoError(t, txn.Set(key(i), val))
+		}
+		require.NotNil(t, txn.spill)
+		require.NoError(t, txn.Commit())
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < n; i++ {
+				item, err := txn.Get(key(i))
+				require.NoError(t, err)
+				var vp valuePointer
+				vp.Decode(item.vptr)
+				require.Equal(t, fid+1, vp.Fid)
+			}
+			return nil
+		}))
+	})
+}
+
+func TestTxnAbandonedReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	// Write the first part of a txn split across requests, and abandon it, as Txn.sendSpilled does.
+	orc := db.orc
+	orc.Lock()
+	ts := orc.nextTxnTs
+	orc.nextTxnTs++
+	orc.txnMark.Begin(ts)
+	orc.Unlock()
+	for _, e := range []*Entry{
+		{Key: y.KeyWithTs([]byte("abandoned"), ts), Value: []byte("abandoned"), meta: bitTxn},
+		{Key: y.KeyWithTs(txnKey, ts), meta: bitFinTxn | bitAbortTxn},
+	} {
+		req, err := db.sendToWriteCh(context.Background(), []*Entry{e})
+		require.NoError(t, err)
+		require.NoError(t, req.Wait())
+	}
+	orc.doneCommit(ts)
+
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("key"), []byte("val"))
+	}))
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("abandoned"))
+			require.Equal(t, ErrKeyNotFound, err)
+			_, err = txn.Get([]byte("key"))
+			require.NoError(t, err)
+			return nil
+		}))
+	}
+	check(db)
+
+	// Simulate a crash by not closing db, but releasing the locks. The value log isn't cut short at
+	// the abandoned txn on replay.
+	if db.dirLockGuard != nil {
+		require.NoError(t, db.dirLockGuard.release())
+	}
+	if db.valueDirGuard != nil {
+		require.NoError(t, db.valueDirGuard.release())
+	}
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
+
+func TestTxnContext(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := db.UpdateCtx(ctx, func(txn *Txn) error {
+			return txn.Set([]byte("key"), []byte("val"))
+		})
+		require.Equal(t, context.Canceled, err)
+
+		// Started before the commit below, so that it doesn't wait for it.
+		other := db.NewTransaction(true)
+		defer other.Discard()
+
+		// Hold up writes, as if they were stalled.
+		db.Lock()
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = db.UpdateCtx(ctx, func(txn *Txn) error {
+			if err := txn.Lock([]byte("key")); err != nil {
+				return err
+			}
+			return txn.Set([]byte("key"), []byte("val"))
+		})
+		require.Equal(t, ErrCommitPending, err)
+
+		// The key lock is held until the writes are done.
+		locked := make(chan struct{})
+		go func() {
+			require.NoError(t, other.Lock([]byte("key")))
+			close(locked)
+		}()
+		select {
+		case <-locked:
+			t.Fatal("Key lock released before the commit was applied")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		// New txns wait for the commit to be applied, unless they give up.
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = db.ViewCtx(ctx, func(txn *Txn) error { return nil })
+		require.Equal(t, context.DeadlineExceeded, err)
+		db.Unlock()
+		<-locked
+
+		// The writes had been handed off, so they go through after all.
+		require.NoError(t, db.ViewCtx(context.Background(), func(txn *Txn) error {
+			item, err := txn.Get([]byte("key"))
+			require.NoError(t, err)
+			val, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, []byte("val"), val)
+			return nil
+		}))
+	})
+}
+
+func TestUpdateWithRetry(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("counter")
+		incr := func(txn *Txn) error {
+			var count int
+			item, err := txn.Get(key)
+			if err == nil {
+				val, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				count, err = strconv.Atoi(string(val))
+				require.NoError(t, err)
+			} else if err != ErrKeyNotFound {
+				return err
+			}
+			return txn.Set(key, []byte(strconv.Itoa(count+1)))
+		}
+
+		var conflicts int32
+		policy := DefaultRetryPolicy
+		policy.MaxAttempts = 0
+		policy.OnConflict = func(n int, err error) {
+			require.Equal(t, ErrConflict, err)
+			atomic.AddInt32(&conflicts, 1)
+		}
+		retries := y.NumTxnRetries.Value()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					require.NoError(t, db.UpdateWithRetry(incr, policy))
+				}
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int64(atomic.LoadInt32(&conflicts)), y.NumTxnRetries.Value()-retries)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get(key)
+			require.NoError(t, err)
+			val, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, "160", string(val))
+			return nil
+		}))
+
+		// A txn which always conflicts runs out of attempts.
+		var attempts []int
+		policy = RetryPolicy{
+			MaxAttempts: 3,
+			OnConflict:  func(n int, err error) { attempts = append(attempts, n) },
+		}
+		err := db.UpdateWithRetry(func(txn *Txn) error {
+			if err := incr(txn); err != nil {
+				return err
+			}
+			return db.Update(incr)
+		}, policy)
+		require.Equal(t, ErrConflict, err)
+		require.Equal(t, []int{1, 2, 3}, attempts)
+
+		// Other errors aren't retried.
+		attempts = nil
+		err = db.UpdateWithRetry(func(txn *Txn) error { return ErrEmptyKey }, policy)
+		require.Equal(t, ErrEmptyKey, err)
+		require.Empty(t, attempts)
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	// The backoff stops doubling at the maximum, which defaults to the one of DefaultRetryPolicy.
+	policy := RetryPolicy{InitialBackoff: time.Millisecond}
+	backoff := policy.InitialBackoff
+	for i := 0; i < 100; i++ {
+		backoff = policy.nextBackoff(backoff)
+	}
+	require.Equal(t, DefaultRetryPolicy.MaxBackoff, backoff)
+
+	policy.MaxBackoff = 5 * time.Millisecond
+	require.Equal(t, 4*time.Millisecond, policy.nextBackoff(2*time.Millisecond))
+	require.Equal(t, 5*time.Millisecond, policy.nextBackoff(4*time.Millisecond))
+	require.Equal(t, 5*time.Millisecond, policy.nextBackoff(5*time.Millisecond))
+
+	require.True(t, isConflict(errors.Wrap(ErrConflict, "commit")))
+	require.True(t, isConflict(&ConflictError{}))
+	require.True(t, isConflict(errors.Wrap(&ConflictError{}, "commit")))
+	require.False(t, isConflict(ErrEmptyKey))
+}
+
+func TestTxnSSI(t *testing.T) {
+	opt := getTestOptions("")
+	opt.SerializableSnapshotIsolation = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		set := func(txn *Txn, key string) {
+			require.NoError(t, txn.Set([]byte(key), []byte("v")))
+		}
+		get := func(txn *Txn, key string) {
+			_, err := txn.Get([]byte(key))
+			require.True(t, err == nil || err == ErrKeyNotFound)
+		}
+
+		// Write skew: each txn reads both keys, and writes one.
+		txn1 := db.NewTransaction(true)
+		txn2 := db.NewTransaction(true)
+		get(txn1, "x")
+		get(txn1, "y")
+		set(txn1, "x")
+		get(txn2, "x")
+		get(txn2, "y")
+		set(txn2, "y")
+		require.NoError(t, txn1.Commit())
+		require.Equal(t, ErrConflict, txn2.Commit())
+
+		// A single dependency is fine: txn1 gets serialized before txn2, which committed first.
+		txn1 = db.NewTransaction(true)
+		get(txn1, "x")
+		set(txn1, "y")
+		txn2 = db.NewTransaction(true)
+		set(txn2, "x")
+		require.NoError(t, txn2.Commit())
+		require.NoError(t, txn1.Commit())
+
+		// Dangerous structure: in -> pivot -> out, where out commits first.
+		pivot := db.NewTransaction(true)
+		in := db.NewTransaction(true)
+		out := db.NewTransaction(true)
+		get(pivot, "x")
+		set(pivot, "y")
+		get(in, "y")
+		set(in, "z")
+		set(out, "x")
+		require.NoError(t, out.Commit())
+		require.Equal(t, ErrConflict, pivot.Commit())
+		require.NoError(t, in.Commit())
+
+		// Same, but the pivot commits before in reads. The pivot can't be aborted anymore, so in
+		// fails instead.
+		pivot = db.NewTransaction(true)
+		in = db.NewTransaction(true)
+		out = db.NewTransaction(true)
+		get(pivot, "x")
+		set(pivot, "y")
+		set(out, "x")
+		require.NoError(t, out.Commit())
+		require.NoError(t, pivot.Commit())
+		get(in, "y")
+		set(in, "z")
+		require.Equal(t, ErrConflict, in.Commit())
+
+		db.orc.Lock()
+		require.Empty(t, db.orc.ssiRunning)
+		db.orc.Unlock()
+	})
+
+	opt.KeepConflictKeys = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		// Write skew again. txn2 read x, written by txn1, which in turn read y, written by txn2.
+		txn1 := db.NewTransaction(true)
+		txn2 := db.NewTransaction(true)
+		for _, txn := range []*Txn{txn1, txn2} {
+			for _, key := range []string{"x", "y"} {
+				_, err := txn.Get([]byte(key))
+				require.Equal(t, ErrKeyNotFound, err)
+			}
+		}
+		require.NoError(t, txn1.Set([]byte("x"), []byte("v")))
+		require.NoError(t, txn2.Set([]byte("y"), []byte("v")))
+		require.NoError(t, txn1.Commit())
+		commitTs := db.orc.nextTs() - 1
+
+		err := txn2.Commit()
+		cerr, ok := err.(*ConflictError)
+		require.True(t, ok, "Expected a *ConflictError. Got: %v", err)
+		require.Equal(t, [][]byte{[]byte("x"), []byte("y")}, cerr.Keys)
+		require.Equal(t, commitTs, cerr.CommitTs)
+	})
+}
+
+func TestTxnSSIJointAccounts(t *testing.T) {
+	opt := getTestOptions("")
+	opt.SerializableSnapshotIsolation = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		// Accounts x and y are joint, and have to keep a balance of at least 100 together. A
+		// withdrawal reads both balances, but only writes the one it withdraws from, and another
+		// account to move the money to. Without the read-write dependencies of the concurrent
+		// withdrawals from x and y caught, the joint balance drops below 100.
+		bal := func(txn *Txn, key string) (int, error) {
+			item, err := txn.Get([]byte(key))
+			if err == ErrKeyNotFound {
+				return 0, nil
+			} else if err != nil {
+				return 0, err
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return 0, err
+			}
+			return strconv.Atoi(string(v))
+		}
+		errAbandoned := errors.New("Insufficient joint balance")
+		move := func(txn *Txn, from, to string) error {
+			var bals [4]int
+			for i, key := range []string{"x", "y", from, to} {
+				var err error
+				if bals[i], err = bal(txn, key); err != nil {
+					return err
+				}
+			}
+			if bals[0]+bals[1]-5 < 100 {
+				return errAbandoned
+			}
+			if err := txn.Set([]byte(from), []byte(strconv.Itoa(bals[2]-5))); err != nil {
+				return err
+			}
+			return txn.Set([]byte(to), []byte(strconv.Itoa(bals[3]+5)))
+		}
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			require.NoError(t, txn.Set([]byte("x"), []byte("100")))
+			return txn.Set([]byte("y"), []byte("100"))
+		}))
+
+		var conflicts int32
+		errCh := make(chan error, 8)
+		for i := 0; i < cap(errCh); i++ {
+			from, to := []string{"x", "y"}[i%2], fmt.Sprintf("out%d", i)
+			go func() {
+				for {
+					err := db.Update(func(txn *Txn) error { return move(txn, from, to) })
+					switch err {
+					case nil:
+					case ErrConflict:
+						atomic.AddInt32(&conflicts, 1)
+					case errAbandoned:
+						errCh <- nil
+						return
+					default:
+						errCh <- err
+						return
+					}
+				}
+			}()
+		}
+		for i := 0; i < cap(errCh); i++ {
+			require.NoError(t, <-errCh)
+		}
+		t.Logf("Conflicts: %d", atomic.LoadInt32(&conflicts))
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			var joint, out int
+			for _, key := range []string{"x", "y"} {
+				n, err := bal(txn, key)
+				require.NoError(t, err)
+				joint += n
+			}
+			require.Equal(t, 100, joint)
+			for i := 0; i < cap(errCh); i++ {
+				n, err := bal(txn, fmt.Sprintf("out%d", i))
+				require.NoError(t, err)
+				out += n
+			}
+			require.Equal(t, 100, out)
+			return nil
+		}))
+	})
+}
+
 // a3, a2, b4 (del), b3, c2, c1
 // Read at ts=4 -> a3, c2
 // Read at ts=4(Uncomitted) -> a3, b4
@@ -843,3 +1722,241 @@ func TestArmV7Issue311Fix(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestTxnGetMany(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+		val := func(i int) []byte {
+			if i%2 == 0 {
+				return []byte(fmt.Sprintf("%0100d", i)) // Stored in the value log.
+			}
+			return []byte(fmt.Sprintf("%d", i))
+		}
+		// Spread the keys over the levels and the memtable, with some deleted or overwritten.
+		n := 3000
+		for _, step := range []int{1, 3, 7} {
+			batch := db.NewWriteBatch()
+			for i := 0; i < n; i += step {
+				if i%5 == 0 && step > 1 {
+					require.NoError(t, batch.Delete(key(i)))
+					continue
+				}
+				require.NoError(t, batch.Set(key(i), val(i), 0))
+			}
+			require.NoError(t, batch.Flush())
+		}
+
+		check := func(txn *Txn, keys [][]byte) {
+			items, errs := txn.GetMany(keys)
+			require.Len(t, items, len(keys))
+			for i, key := range keys {
+				item, err := txn.Get(key)
+				require.Equal(t, err, errs[i], "key: %s", key)
+				if err != nil {
+					require.Nil(t, items[i])
+					continue
+				}
+				require.Equal(t, item.Key(), items[i].Key())
+				require.Equal(t, item.Version(), items[i].Version())
+				expected, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				got, err := items[i].ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, expected, got)
+			}
+		}
+		var keys [][]byte
+		for i := 0; i < 500; i++ {
+			keys = append(keys, key(rand.Intn(n+100)))
+		}
+		keys = append(keys, key(1), key(1), nil)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			check(txn, keys)
+			return nil
+		}))
+
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set(key(1), []byte("pending")))
+		require.NoError(t, txn.Delete(key(2)))
+		check(txn, keys)
+		items, errs := txn.GetMany([][]byte{key(2), key(1)})
+		require.Equal(t, ErrKeyNotFound, errs[0])
+		require.NoError(t, errs[1])
+		v, err := items[1].ValueCopy(nil)
+		require.NoError(t, err)
+		require.Equal(t, []byte("pending"), v)
+	})
+}
+
+func TestTxnAtVersion(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	val := func(round, i int) []byte { return []byte(fmt.Sprintf("%d-%d", round, i)) }
+	n := 20
+	// write overwrites all the keys in each round, returning the commit timestamps of the rounds.
+	write := func(t *testing.T, db *DB, rounds int) []uint64 {
+		var versions []uint64
+		for round := 0; round < rounds; round++ {
+			txn := db.NewTransaction(true)
+			for i := 0; i < n; i++ {
+				require.NoError(t, txn.Set(key(i), val(round, i)))
+			}
+			require.NoError(t, txn.Commit())
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get(key(0))
+				require.NoError(t, err)
+				versions = append(versions, item.Version())
+				return nil
+			}))
+		}
+		// Push the versions out of the memtable, so compactions get to discard them.
+		batch := db.NewWriteBatch()
+		for i := 0; i < 2000; i++ {
+			require.NoError(t, batch.Set([]byte(fmt.Sprintf("filler%05d", i)), []byte("filler"), 0))
+		}
+		require.NoError(t, batch.Flush())
+		return versions
+	}
+	check := func(t *testing.T, txn *Txn, round int) {
+		for i := 0; i < n; i++ {
+			item, err := txn.Get(key(i))
+			require.NoError(t, err)
+			require.Equal(t, val(round, i), getItemValue(t, item))
+		}
+	}
+
+	t.Run("retention", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "badger")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		opt := getTestOptions(dir)
+		opt.VersionRetention = time.Hour
+		db, err := Open(opt)
+		require.NoError(t, err)
+		defer db.Close()
+
+		versions := write(t, db, 5)
+		require.NoError(t, db.Flatten(1))
+		for round, version := range versions {
+			txn, err := db.NewTransactionAtVersion(version)
+			require.NoError(t, err)
+			check(t, txn, round)
+			txn.Discard()
+		}
+		_, err = db.NewTransactionAtVersion(math.MaxUint64) // Not committed yet.
+		require.Error(t, err)
+	})
+
+	t.Run("no retention", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "badger")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		db, err := Open(getTestOptions(dir))
+		require.NoError(t, err)
+		defer db.Close()
+
+		versions := write(t, db, 3)
+		// A txn at a past version keeps the versions it reads around.
+		txn, err := db.NewTransactionAtVersion(versions[1])
+		require.NoError(t, err)
+		write(t, db, 2)
+		require.NoError(t, db.Flatten(1))
+		check(t, txn, 1)
+		txn.Discard()
+
+		_, err = db.NewTransactionAtVersion(versions[0])
+		require.Equal(t, ErrVersionDiscarded, err)
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "badger")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		opt := getTestOptions(dir)
+		opt.VersionRetention = time.Hour
+		db, err := Open(opt)
+		require.NoError(t, err)
+		versions := write(t, db, 3)
+		latest := db.orc.nextTs() - 1
+		require.NoError(t, db.Close())
+
+		// It's not known which versions were discarded before, except for the latest one.
+		db, err = Open(opt)
+		require.NoError(t, err)
+		defer db.Close()
+		_, err = db.NewTransactionAtVersion(versions[2])
+		require.Equal(t, ErrVersionDiscarded, err)
+		txn, err := db.NewTransactionAtVersion(latest)
+		require.NoError(t, err)
+		check(t, txn, 2)
+		txn.Discard()
+
+		versions = write(t, db, 2)
+		require.NoError(t, db.Flatten(1))
+		for round, version := range versions {
+			txn, err := db.NewTransactionAtVersion(version)
+			require.NoError(t, err)
+			check(t, txn, round)
+			txn.Discard()
+		}
+	})
+}
+
+func TestTxnHistory(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		big := make([]byte, 1<<10) // Stored in the value log.
+		update := func(fn func(txn *Txn) error) uint64 {
+			txn := db.NewTransaction(true)
+			defer txn.Discard()
+			require.NoError(t, fn(txn))
+			require.NoError(t, txn.Commit())
+			return db.orc.nextTs() - 1
+		}
+		v1 := update(func(txn *Txn) error { return txn.SetWithMeta(key, []byte("v1"), 1) })
+		v2 := update(func(txn *Txn) error { return txn.SetWithTTL(key, big, time.Hour) })
+		v3 := update(func(txn *Txn) error { return txn.Delete(key) })
+		v4 := update(func(txn *Txn) error { return txn.Set(key, []byte("v4")) })
+		update(func(txn *Txn) error { return txn.Set([]byte("other"), []byte("other")) })
+
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set(key, []byte("pending")))
+
+		history, err := txn.History(key, 0)
+		require.NoError(t, err)
+		require.Len(t, history, 4)
+		require.Equal(t, KeyVersion{Version: v4, Value: []byte("v4")}, history[0])
+		require.Equal(t, KeyVersion{Version: v3, Deleted: true}, history[1])
+		require.Equal(t, v2, history[2].Version)
+		require.Equal(t, big, history[2].Value)
+		require.True(t, history[2].ExpiresAt > uint64(time.Now().Unix()))
+		require.Equal(t, KeyVersion{Version: v1, Value: []byte("v1"), UserMeta: 1}, history[3])
+
+		history, err = txn.History(key, 2)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, v3, history[1].Version)
+
+		// History can be called while the txn has an iterator open.
+		itr := txn.NewIterator(DefaultIteratorOptions)
+		history, err = txn.History(key, 1)
+		require.NoError(t, err)
+		require.Equal(t, []KeyVersion{{Version: v4, Value: []byte("v4")}}, history)
+		itr.Close()
+
+		history, err = txn.History([]byte("missing"), 0)
+		require.NoError(t, err)
+		require.Empty(t, history)
+
+		// Versions before one set with SetWithDiscard are gone.
+		v5 := update(func(txn *Txn) error { return txn.SetWithDiscard(key, []byte("v5"), 0) })
+		require.NoError(t, db.View(func(txn *Txn) error {
+			history, err := txn.History(key, 0)
+			require.NoError(t, err)
+			require.Equal(t, []KeyVersion{{Version: v5, Value: []byte("v5")}}, history)
+			return nil
+		}))
+	})
+}
@@ -19,6 +19,7 @@ package badger
 import (
 	"io/ioutil"
 	"math/rand"
+	"os"
 	"sync/atomic"
 	"time"
 
@@ -117,6 +118,26 @@ func (s *levelsController) reserveFileID() uint64 {
 	return id - 1
 }
 
+// writeRateLimited writes data to f in chunks, waiting on each of rls before each one. This spreads
+// out large background writes, instead of writing them out in one burst.
+func writeRateLimited(f *os.File, data []byte, rls ...*y.RateLimiter) error {
+	const chunkSize = 1 << 20
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		for _, rl := range rls {
+			rl.Wait(n)
+		}
+		if _, err := f.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
 func getIDMap(dir string) map[uint64]struct{} {
 	fileInfos, err := ioutil.ReadDir(dir)
 	y.Check(err)
@@ -47,9 +47,10 @@ const (
 	bitValuePointer           byte = 1 << 1 // Set if the value is NOT stored directly next to key.
 	bitDiscardEarlierVersions byte = 1 << 2 // Set if earlier versions can be discarded.
 
-	// The MSB 2 bits are for transactions.
-	bitTxn    byte = 1 << 6 // Set if the entry is part of a txn.
-	bitFinTxn byte = 1 << 7 // Set if the entry is to indicate end of txn in value log.
+	// The MSB 3 bits are for transactions.
+	bitAbortTxn byte = 1 << 5 // Set along with bitFinTxn if the txn was abandoned instead.
+	bitTxn      byte = 1 << 6 // Set if the entry is part of a txn.
+	bitFinTxn   byte = 1 << 7 // Set if the entry is to indicate end of txn in value log.
 
 	mi int64 = 1 << 20
 )
@@ -306,6 +307,15 @@ func (vlog *valueLog) iterate(lf *logFile, offset uint32, fn logEntry) (uint32,
 				break
 			}
 
+		} else if e.meta&bitAbortTxn > 0 {
+			// The txn was abandoned after some of its requests had been written. See
+			// Txn.sendSpilled. Skip it, as replayFunction does.
+			if lastCommit != y.ParseTs(e.Key) {
+				break
+			}
+			lastCommit = 0
+			validEndOffset = read.recordOffset
+
 		} else if e.meta&bitFinTxn > 0 {
 			txnTs, err := strconv.ParseUint(string(e.Value), 10, 64)
 			if err != nil || lastCommit != txnTs {
@@ -338,6 +348,7 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 	maxFid := atomic.LoadUint32(&vlog.maxFid)
 	y.AssertTruef(uint32(f.fid) < maxFid, "fid to move: %d. Current max fid: %d", f.fid, maxFid)
 	tr.LazyPrintf("Rewriting fid: %d", f.fid)
+	timeStart := time.Now()
 
 	wb := make([]*Entry, 0, 1000)
 	var size int64
@@ -436,6 +447,13 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 			}
 			return err
 		}
+		// Account for the rewritten entries, so that GC doesn't hog the disk.
+		var sz int
+		for _, e := range wb[i:end] {
+			sz += len(e.Key) + len(e.Value)
+		}
+		vlog.db.rateLimiter.Wait(sz)
+		vlog.db.compactionRateLimiter.Wait(sz)
 		i += batchSize
 	}
 	tr.LazyPrintf("Processed %d entries in %d loops", len(wb), loops)
@@ -463,6 +481,12 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 		vlog.deleteLogFile(f)
 	}
 
+	vlog.opt.EventListener.OnVlogGC(VlogGCInfo{
+		Fid:      f.fid,
+		Count:    count,
+		Moved:    moved,
+		Duration: time.Since(timeStart),
+	})
 	return nil
 }
 
@@ -885,12 +909,27 @@ func (vlog *valueLog) sortedFids() []uint32 {
 type request struct {
 	// Input values
 	Entries []*Entry
+	// Set on the first request of a txn split across requests, to the size the whole txn takes in
+	// the value log. See Txn.sendSpilled.
+	txnSize int64
 	// Output values and wait group stuff below
 	Ptrs []valuePointer
 	Wg   sync.WaitGroup
 	Err  error
 }
 
+// leavesTxnOpen returns true if the request holds part of a txn, to be closed by a later request.
+// See Txn.sendSpilled.
+func (req *request) leavesTxnOpen() bool {
+	n := len(req.Entries)
+	return n > 0 && req.Entries[n-1].meta&bitTxn > 0
+}
+
+// abortsTxn returns true if the request closes a txn split across requests without committing it.
+func (req *request) abortsTxn() bool {
+	return len(req.Entries) == 1 && req.Entries[0].meta&bitAbortTxn > 0
+}
+
 func (req *request) Wait() error {
 	req.Wg.Wait()
 	req.Entries = nil
@@ -938,6 +977,23 @@ func (vlog *valueLog) write(reqs []*request) error {
 	vlog.filesLock.RUnlock()
 
 	var buf bytes.Buffer
+	// Set while the last request written leaves its txn open. The rest of the txn has to go into
+	// the same file, so don't switch files.
+	var txnOpen bool
+	rotate := func() error {
+		if err := curlf.doneWriting(vlog.woffset()); err != nil {
+			return err
+		}
+
+		newid := atomic.AddUint32(&vlog.maxFid, 1)
+		y.AssertTruef(newid > 0, "newid has overflown uint32: %v", newid)
+		newlf, err := vlog.createVlogFile(newid)
+		if err != nil {
+			return err
+		}
+		curlf = newlf
+		return nil
+	}
 	toDisk := func() error {
 		if buf.Len() == 0 {
 			return nil
@@ -953,20 +1009,9 @@ func (vlog *valueLog) write(reqs []*request) error {
 		vlog.elog.Printf("Done")
 		atomic.AddUint32(&vlog.writableLogOffset, uint32(n))
 
-		if vlog.woffset() > uint32(vlog.opt.ValueLogFileSize) ||
-			vlog.numEntriesWritten > vlog.opt.ValueLogMaxEntries {
-			var err error
-			if err = curlf.doneWriting(vlog.woffset()); err != nil {
-				return err
-			}
-
-			newid := atomic.AddUint32(&vlog.maxFid, 1)
-			y.AssertTruef(newid > 0, "newid has overflown uint32: %v", newid)
-			newlf, err := vlog.createVlogFile(newid)
-			if err != nil {
-				return err
-			}
-			curlf = newlf
+		if !txnOpen && (vlog.woffset() > uint32(vlog.opt.ValueLogFileSize) ||
+			vlog.numEntriesWritten > vlog.opt.ValueLogMaxEntries) {
+			return rotate()
 		}
 		return nil
 	}
@@ -974,6 +1019,19 @@ func (vlog *valueLog) write(reqs []*request) error {
 	for i := range reqs {
 		b := reqs[i]
 		b.Ptrs = b.Ptrs[:0]
+		if b.txnSize > 0 &&
+			int64(vlog.woffset())+int64(buf.Len())+b.txnSize > vlog.opt.ValueLogFileSize {
+			// The txn opened by b won't fit into what's left of the file. Start a new one, so the
+			// file doesn't grow past ValueLogFileSize while the txn is open.
+			if err := toDisk(); err != nil {
+				return err
+			}
+			if vlog.woffset() > 0 {
+				if err := rotate(); err != nil {
+					return err
+				}
+			}
+		}
 		for j := range b.Entries {
 			e := b.Entries[j]
 			var p valuePointer
@@ -989,6 +1047,7 @@ func (vlog *valueLog) write(reqs []*request) error {
 			b.Ptrs = append(b.Ptrs, p)
 		}
 		vlog.numEntriesWritten += uint32(len(b.Entries))
+		txnOpen = b.leavesTxnOpen()
 		// We write to disk here so that all entries that are part of the same transaction are
 		// written to the same vlog file.
 		writeNow :=
@@ -1038,6 +1097,84 @@ func (vlog *valueLog) Read(vp valuePointer, s *y.Slice) ([]byte, func(), error)
 	return buf[n : n+h.vlen], cb, nil
 }
 
+// readMany reads the values pointed to by vps. The reads are grouped by value log file, taking the
+// read lock on each file once, and adjacent values are read together. The values of a file which
+// has been garbage collected are left nil, to be looked up under the move keys via Read instead.
+func (vlog *valueLog) readMany(vps []valuePointer) ([][]byte, error) {
+	order := make([]int, len(vps))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := vps[order[i]], vps[order[j]]
+		if a.Fid != b.Fid {
+			return a.Fid < b.Fid
+		}
+		return a.Offset < b.Offset
+	})
+
+	vals := make([][]byte, len(vps))
+	for start := 0; start < len(order); {
+		end := start + 1
+		for end < len(order) && vps[order[end]].Fid == vps[order[start]].Fid {
+			end++
+		}
+		if err := vlog.readFromFile(vps, order[start:end], vals); err != nil {
+			return nil, err
+		}
+		start = end
+	}
+	return vals, nil
+}
+
+// readFromFile reads the values for readMany, at the given indices of vps, which are all in the
+// same file and sorted by offset.
+func (vlog *valueLog) readFromFile(vps []valuePointer, idx []int, vals [][]byte) error {
+	fid := vps[idx[0]].Fid
+	lf, err := vlog.getFileRLocked(fid)
+	if err == ErrRetry {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lf.lock.RUnlock()
+
+	maxFid := atomic.LoadUint32(&vlog.maxFid)
+	for i := 0; i < len(idx); {
+		// Extend the read over the values which follow each other in the file.
+		span := vps[idx[i]]
+		j := i + 1
+		for ; j < len(idx) && vps[idx[j]].Offset == span.Offset+span.Len; j++ {
+			span.Len += vps[idx[j]].Len
+		}
+		if fid == maxFid && span.Offset+span.Len > vlog.woffset() {
+			return errors.Errorf(
+				"Invalid value pointer offset: %d greater than current offset: %d",
+				span.Offset+span.Len, vlog.woffset())
+		}
+
+		buf, err := lf.read(span, new(y.Slice))
+		if err != nil {
+			return err
+		}
+		for ; i < j; i++ {
+			vp := vps[idx[i]]
+			b := buf[vp.Offset-span.Offset : vp.Offset-span.Offset+vp.Len]
+			var h header
+			h.Decode(b)
+			n := uint32(headerBufSize) + h.klen
+			val := b[n : n+h.vlen]
+			if lf.loadingMode == options.MemoryMap {
+				// The mmap can go away once we release the lock.
+				val = y.SafeCopy(nil, val)
+			}
+			vals[idx[i]] = val
+		}
+	}
+	return nil
+}
+
 func (vlog *valueLog) readValueBytes(vp valuePointer, s *y.Slice) ([]byte, func(), error) {
 	lf, err := vlog.getFileRLocked(vp.Fid)
 	if err != nil {
@@ -1054,7 +1191,7 @@ func (vlog *valueLog) readValueBytes(vp valuePointer, s *y.Slice) ([]byte, func(
 	return buf, nil, err
 }
 
-// Test helper
+// valueBytesToEntry decodes an entry encoded via encodeEntry. The entry points into buf.
 func valueBytesToEntry(buf []byte) (e Entry) {
 	var h header
 	h.Decode(buf)
@@ -1064,6 +1201,7 @@ func valueBytesToEntry(buf []byte) (e Entry) {
 	n += h.klen
 	e.meta = h.meta
 	e.UserMeta = h.userMeta
+	e.ExpiresAt = h.expiresAt
 	e.Value = buf[n : n+h.vlen]
 	return
 }
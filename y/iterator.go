@@ -94,6 +94,9 @@ type Iterator interface {
 	Key() []byte
 	Value() ValueStruct
 	Valid() bool
+	// SetReversed changes the direction of iteration. The iterator has to be repositioned with
+	// Rewind or Seek afterwards.
+	SetReversed(reversed bool)
 
 	// All iterators should be closed so that file garbage collection works.
 	Close() error
@@ -253,6 +256,15 @@ func (s *MergeIterator) Seek(key []byte) {
 	s.initHeap()
 }
 
+// SetReversed changes the direction of all the merged iterators.
+func (s *MergeIterator) SetReversed(reversed bool) {
+	s.reversed = reversed
+	for _, itr := range s.all {
+		itr.SetReversed(reversed)
+	}
+	s.h = s.h[:0] // The heap is rebuilt on Rewind or Seek.
+}
+
 // Close implements y.Iterator
 func (s *MergeIterator) Close() error {
 	for _, itr := range s.all {
@@ -77,6 +77,7 @@ func (s *SimpleIterator) Value() ValueStruct {
 func (s *SimpleIterator) Valid() bool {
 	return s.idx >= 0 && s.idx < len(s.keys)
 }
+func (s *SimpleIterator) SetReversed(reversed bool) { s.reversed = reversed }
 
 func newSimpleIterator(keys []string, vals []string, reversed bool) *SimpleIterator {
 	k := make([][]byte, len(keys))
@@ -232,3 +233,19 @@ func TestMergeIteratorSeekInvalidReversed(t *testing.T) {
 	require.False(t, mergeIt.Valid())
 	closeAndCheck(t, mergeIt, 4)
 }
+
+func TestMergeIteratorSetReversed(t *testing.T) {
+	it := newSimpleIterator([]string{"1", "3", "7"}, []string{"a1", "a3", "a7"}, false)
+	it2 := newSimpleIterator([]string{"2", "3", "5"}, []string{"b2", "b3", "b5"}, false)
+	mergeIt := NewMergeIterator([]Iterator{it, it2}, false)
+	mergeIt.Seek([]byte("3"))
+	k, _ := getAll(mergeIt)
+	require.EqualValues(t, []string{"3", "5", "7"}, k)
+
+	mergeIt.SetReversed(true)
+	mergeIt.Seek([]byte("3"))
+	k, v := getAll(mergeIt)
+	require.EqualValues(t, []string{"3", "2", "1"}, k)
+	require.EqualValues(t, []string{"a3", "b2", "a1"}, v)
+	closeAndCheck(t, mergeIt, 2)
+}
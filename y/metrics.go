@@ -48,6 +48,14 @@ var (
 	NumBlockedPuts *expvar.Int
 	// NumMemtableGets is number of memtable gets
 	NumMemtableGets *expvar.Int
+	// NumTrivialMoves is number of tables moved to the next level without being rewritten
+	NumTrivialMoves *expvar.Int
+	// NumCompactionBytesWritten has cumulative number of bytes written to tables by compactions
+	NumCompactionBytesWritten *expvar.Int
+	// NumTxnRetries is number of txns retried by UpdateWithRetry after a conflict
+	NumTxnRetries *expvar.Int
+	// NumTxnRetriesExhausted is number of times UpdateWithRetry gave up after conflicts
+	NumTxnRetriesExhausted *expvar.Int
 )
 
 // These variables are global and have cumulative values for all kv stores.
@@ -62,6 +70,10 @@ func init() {
 	NumPuts = expvar.NewInt("badger_puts_total")
 	NumBlockedPuts = expvar.NewInt("badger_blocked_puts_total")
 	NumMemtableGets = expvar.NewInt("badger_memtable_gets_total")
+	NumTrivialMoves = expvar.NewInt("badger_compaction_trivial_moves_total")
+	NumCompactionBytesWritten = expvar.NewInt("badger_compaction_written_bytes")
+	NumTxnRetries = expvar.NewInt("badger_txn_retries_total")
+	NumTxnRetriesExhausted = expvar.NewInt("badger_txn_retries_exhausted_total")
 	LSMSize = expvar.NewMap("badger_lsm_size_bytes")
 	VlogSize = expvar.NewMap("badger_vlog_size_bytes")
 	PendingWrites = expvar.NewMap("badger_pending_writes_total")
@@ -220,3 +220,70 @@ func (lc *Closer) SignalAndWait() {
 	lc.Signal()
 	lc.Wait()
 }
+
+// RateLimiter is a token bucket, used to limit the rate at which bytes get written to disk. A
+// rate of zero or less disables the limit. It is safe for concurrent use, and the rate can be
+// changed at any time.
+type RateLimiter struct {
+	sync.Mutex
+	rate   int64   // Bytes per second.
+	tokens float64 // Bytes which can be written right away. Negative if we're in debt.
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate bytes per second.
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// SetRate changes the number of bytes per second allowed by the RateLimiter.
+func (r *RateLimiter) SetRate(rate int64) {
+	r.Lock()
+	defer r.Unlock()
+	r.refill()
+	r.rate = rate
+	if r.tokens > float64(rate) {
+		r.tokens = float64(rate)
+	}
+}
+
+// Rate returns the number of bytes per second allowed by the RateLimiter.
+func (r *RateLimiter) Rate() int64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.rate
+}
+
+// refill adds the tokens accumulated since the last call. Bursts are capped at one second worth of
+// bytes. Must be called with the lock held.
+func (r *RateLimiter) refill() {
+	now := time.Now()
+	if r.rate > 0 {
+		r.tokens += now.Sub(r.last).Seconds() * float64(r.rate)
+		if r.tokens > float64(r.rate) {
+			r.tokens = float64(r.rate)
+		}
+	}
+	r.last = now
+}
+
+// Wait blocks until n bytes can be written. Requests larger than the burst size are allowed to go
+// into debt, which is then paid off by the callers that follow.
+func (r *RateLimiter) Wait(n int) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	r.refill()
+	if r.rate <= 0 {
+		r.Unlock()
+		return
+	}
+	r.tokens -= float64(n)
+	var wait time.Duration
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / float64(r.rate) * float64(time.Second))
+	}
+	r.Unlock()
+	time.Sleep(wait)
+}
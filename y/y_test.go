@@ -0,0 +1,46 @@
+/*
+ * Copyright 2018 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package y
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var nilLimiter *RateLimiter
+	nilLimiter.Wait(1 << 30) // Must not block.
+
+	r := NewRateLimiter(0)
+	start := time.Now()
+	r.Wait(1 << 30)
+	require.True(t, time.Since(start) < 100*time.Millisecond)
+
+	// The first 10KB are covered by the initial burst. The next 5KB should take half a second.
+	r.SetRate(10 << 10)
+	time.Sleep(time.Second)
+	start = time.Now()
+	r.Wait(10 << 10)
+	r.Wait(5 << 10)
+	r.Wait(0)
+	dur := time.Since(start)
+	require.True(t, dur >= 400*time.Millisecond, "took %s", dur)
+	require.True(t, dur < 2*time.Second, "took %s", dur)
+	require.Equal(t, int64(10<<10), r.Rate())
+}